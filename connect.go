@@ -28,12 +28,29 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
 )
 
-const configurationUpdatesSubject = "$memphis_sdk_configurations_updates"
+const (
+	configurationUpdatesSubject = "$memphis_sdk_configurations_updates"
+	maxNameLength               = 128
+	connEventsBufferSize        = 64
+	auditEventsBufferSize       = 1024
+	defaultProduceSubjectSuffix = ".final"
+	defaultConnectTimeout       = 15 * time.Second
+	defaultJsAPIPrefix          = "$JS.API."
+)
+
+var (
+	ErrInvalidName        = errors.New("invalid name")
+	ErrDisconnected       = errors.New("memphis connection is disconnected")
+	ErrBrokerUnresponsive = errors.New("memphis broker is unresponsive")
+	ErrSchemaFetchTimeout = errors.New("timed out fetching the station's schema")
+	ErrStationNotFound    = errors.New("station not found")
+)
 
 // Option is a function on the options for a connection.
 type Option func(*Options) error
@@ -47,17 +64,50 @@ type TLSOpts struct {
 }
 
 type Options struct {
-	Host              string
-	Port              int
-	Username          string
-	ConnectionToken   string
-	Reconnect         bool
-	MaxReconnect      int
-	ReconnectInterval time.Duration
-	Timeout           time.Duration
-	TLSOpts           TLSOpts
+	Host                     string
+	Port                     int
+	Username                 string
+	ConnectionToken          string
+	Reconnect                bool
+	MaxReconnect             int
+	ReconnectInterval        time.Duration
+	Timeout                  time.Duration
+	TLSOpts                  TLSOpts
+	MaxHeadersSize           int
+	MaxHeadersCount          int
+	Servers                  []string
+	PingInterval             time.Duration
+	MaxPingsOut              int
+	JSONMarshaler            JSONMarshalFunc
+	JSONUnmarshaler          JSONUnmarshalFunc
+	ProduceSubjectSuffix     string
+	SchemaFetchTimeout       time.Duration
+	SchemaFetchFallback      SchemaFetchPolicy
+	SchemaFetchAttempts      int
+	SchemaFetchBackoff       BackoffStrategy
+	UnknownSchemaPassthrough bool
+	AuditSink                AuditSink
+	MaxConcurrentSchemaOps   int
+	InboxPrefix              string
+	TLSServerName            string
 }
 
+// SchemaFetchPolicy - what producers do when subscribing to a station's schema updates times out.
+type SchemaFetchPolicy int
+
+const (
+	// SchemaFetchFail - fail the producer creation or the produce.
+	SchemaFetchFail SchemaFetchPolicy = iota
+	// SchemaFetchRawBytes - produce without client side schema validation, subscribing again on the next produce.
+	SchemaFetchRawBytes
+)
+
+// JSONMarshalFunc - marshals a value to JSON, same as json.Marshal.
+type JSONMarshalFunc func(any) ([]byte, error)
+
+// JSONUnmarshalFunc - unmarshals JSON into a value, same as json.Unmarshal.
+type JSONUnmarshalFunc func([]byte, any) error
+
 type queryReq struct {
 	resp chan bool
 }
@@ -72,6 +122,79 @@ func (c *Conn) IsConnected() bool {
 	return c.brokerConn.IsConnected()
 }
 
+// Conn.Ping - verifies a round trip to the broker completes within timeout.
+func (c *Conn) Ping(timeout time.Duration) error {
+	if !c.IsConnected() {
+		return ErrDisconnected
+	}
+	if err := c.brokerConn.FlushTimeout(timeout); err != nil {
+		return fmt.Errorf("%w: %v", ErrBrokerUnresponsive, memphisError(err))
+	}
+	return nil
+}
+
+// Conn.ConnectionId - get the connection's id, it is generated once on Connect and stays the same across reconnects.
+func (c *Conn) ConnectionId() string {
+	return c.ConnId
+}
+
+// Conn.ServerInfo - get details of the broker server currently connected to.
+func (c *Conn) ServerInfo() ServerInfo {
+	return ServerInfo{
+		Version:     c.brokerConn.ConnectedServerVersion(),
+		Url:         c.brokerConn.ConnectedUrlRedacted(),
+		Host:        c.brokerConn.ConnectedAddr(),
+		ServerId:    c.brokerConn.ConnectedServerId(),
+		ServerName:  c.brokerConn.ConnectedServerName(),
+		ClusterName: c.brokerConn.ConnectedClusterName(),
+		Username:    c.username,
+	}
+}
+
+// Conn.Stats - get the connection's statistics.
+func (c *Conn) Stats() ConnStats {
+	brokerStats := c.brokerConn.Stats()
+
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	return ConnStats{
+		InMsgs:            brokerStats.InMsgs,
+		OutMsgs:           brokerStats.OutMsgs,
+		InBytes:           brokerStats.InBytes,
+		OutBytes:          brokerStats.OutBytes,
+		Reconnects:        brokerStats.Reconnects,
+		Produces:          c.produces,
+		ProduceFailures:   c.produceFailures,
+		CircuitRejections: c.circuitRejections,
+		OpenCircuits:      c.openCircuits(),
+	}
+}
+
+func (c *Conn) recordProduce(err error) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	c.produces++
+	if err != nil {
+		c.produceFailures++
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		c.circuitRejections++
+	}
+}
+
+// Conn.openCircuits - the number of the connection's producers whose circuit breaker isn't closed.
+func (c *Conn) openCircuits() int {
+	c.entitiesMu.Lock()
+	defer c.entitiesMu.Unlock()
+	open := 0
+	for p := range c.producers {
+		if p.CircuitState() != CircuitClosed {
+			open++
+		}
+	}
+	return open
+}
+
 func (c *Conn) getProducersMap() ProducersMap {
 	return c.producersMap
 }
@@ -89,9 +212,58 @@ type Conn struct {
 	js                 nats.JetStreamContext
 	stationUpdatesMu   sync.RWMutex
 	stationUpdatesSubs map[string]*stationUpdateSub
+	injectedSchemas    map[string]schemaDetails
+	eventsMu           sync.RWMutex
+	events             chan ConnEvent
+	eventsClosed       bool
+	droppedEvents      uint64
+	schemaOps          chan struct{}
+	auditMu            sync.RWMutex
+	auditEvents        chan any
+	auditClosed        bool
+	auditDone          chan struct{}
+	droppedAuditEvents uint64
 	configUpdatesMu    sync.RWMutex
 	configUpdatesSub   configurationsUpdateSub
+	jsAPIPrefix        string
+	producersMapMu     sync.RWMutex
 	producersMap       ProducersMap
+	stationProducersMu sync.Mutex
+	entitiesMu         sync.Mutex
+	producers          map[*Producer]struct{}
+	consumers          map[*Consumer]struct{}
+	schemaUpdateCbsMu  sync.RWMutex
+	schemaUpdateCbs    map[string][]SchemaUpdateHandler
+	schemaErrHandler   SchemaErrorHandler
+	maxPayload         int64
+	statsMu            sync.Mutex
+	produces           uint64
+	produceFailures    uint64
+	circuitRejections  uint64
+}
+
+// ServerInfo - details of the broker server the connection is connected to.
+type ServerInfo struct {
+	Version     string
+	Url         string
+	Host        string
+	ServerId    string
+	ServerName  string
+	ClusterName string
+	Username    string
+}
+
+// ConnStats - connection statistics.
+type ConnStats struct {
+	InMsgs            uint64
+	OutMsgs           uint64
+	InBytes           uint64
+	OutBytes          uint64
+	Reconnects        uint64
+	Produces          uint64
+	ProduceFailures   uint64
+	CircuitRejections uint64
+	OpenCircuits      int
 }
 
 type attachSchemaReq struct {
@@ -108,11 +280,20 @@ type detachSchemaReq struct {
 // getDefaultOptions - returns default configuration options for the client.
 func getDefaultOptions() Options {
 	return Options{
-		Port:              6666,
-		Reconnect:         true,
-		MaxReconnect:      3,
-		ReconnectInterval: 200 * time.Millisecond,
-		Timeout:           15 * time.Second,
+		Port:                 6666,
+		Reconnect:            true,
+		MaxReconnect:         3,
+		ReconnectInterval:    200 * time.Millisecond,
+		Timeout:              defaultConnectTimeout,
+		MaxHeadersSize:       64 * 1024,
+		MaxHeadersCount:      256,
+		PingInterval:         nats.DefaultPingInterval,
+		MaxPingsOut:          nats.DefaultMaxPingOut,
+		JSONMarshaler:        json.Marshal,
+		JSONUnmarshaler:      json.Unmarshal,
+		ProduceSubjectSuffix: defaultProduceSubjectSuffix,
+		SchemaFetchAttempts:  3,
+		SchemaFetchBackoff:   ExponentialBackoff(50*time.Millisecond, 500*time.Millisecond),
 		TLSOpts: TLSOpts{
 			TlsCert: "",
 			TlsKey:  "",
@@ -153,12 +334,41 @@ func Connect(host, username, connectionToken string, options ...Option) (*Conn,
 	}
 	err = conn.listenToConfigurationUpdates()
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
 	return conn, nil
 }
 
+// ValidateConnection - connects with the same parameters as Connect, checks that each of the given stations exists
+// and closes the connection, without creating any resources. Connection failures are returned as is, otherwise
+// the problems of all stations are returned together, a missing station as ErrStationNotFound.
+func ValidateConnection(host, username, connectionToken string, stations []string, options ...Option) error {
+	c, err := Connect(host, username, connectionToken, options...)
+	if err != nil {
+		return memphisError(err)
+	}
+	defer c.Close()
+
+	var errs []error
+	for _, name := range stations {
+		if err := c.stationExists(name); err != nil {
+			errs = append(errs, fmt.Errorf("station %v: %w", name, err))
+		}
+	}
+	return memphisError(joinErrors(errs...))
+}
+
+// Conn.stationExists - checks the station's stream exists, ErrStationNotFound is returned if it doesn't.
+func (c *Conn) stationExists(name string) error {
+	_, err := c.brokerStreamInfo(getInternalName(name))
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		return ErrStationNotFound
+	}
+	return err
+}
+
 func normalizeHost(host string) string {
 	r := regexp.MustCompile("^http(s?)://")
 	return r.ReplaceAllString(host, "")
@@ -190,17 +400,42 @@ func (opts Options) connect() (*Conn, error) {
 		ConnId:       connId,
 		opts:         opts,
 		producersMap: make(ProducersMap),
+		events:       make(chan ConnEvent, connEventsBufferSize),
+	}
+	if opts.AuditSink != nil {
+		c.startAudit(opts.AuditSink)
+	}
+	if opts.MaxConcurrentSchemaOps > 0 {
+		c.schemaOps = make(chan struct{}, opts.MaxConcurrentSchemaOps)
 	}
 
 	if err := c.startConn(); err != nil {
+		c.closeAudit()
 		return nil, memphisError(err)
 	}
+	c.emitEvent(ConnEvent{Type: ConnEventConnected})
 
 	c.stationUpdatesSubs = make(map[string]*stationUpdateSub)
+	c.schemaUpdateCbs = make(map[string][]SchemaUpdateHandler)
+	c.producers = make(map[*Producer]struct{})
+	c.consumers = make(map[*Consumer]struct{})
 
 	return &c, nil
 }
 
+// Options.serverUrls - urls of the additional broker hosts to fail over to, the port is appended to hosts without one.
+func (opts *Options) serverUrls() []string {
+	urls := make([]string, 0, len(opts.Servers))
+	for _, server := range opts.Servers {
+		server = normalizeHost(server)
+		if !strings.Contains(server, ":") {
+			server = server + ":" + strconv.Itoa(opts.Port)
+		}
+		urls = append(urls, server)
+	}
+	return urls
+}
+
 func disconnectedError(conn *nats.Conn, err error) {
 	if err != nil {
 		fmt.Printf("Error %v", err.Error())
@@ -212,14 +447,29 @@ func (c *Conn) startConn() error {
 	var err error
 	url := opts.Host + ":" + strconv.Itoa(opts.Port)
 	natsOpts := nats.Options{
-		Url:               url,
-		AllowReconnect:    opts.Reconnect,
-		MaxReconnect:      opts.MaxReconnect,
-		ReconnectWait:     opts.ReconnectInterval,
-		Timeout:           opts.Timeout,
-		Token:             opts.ConnectionToken,
-		DisconnectedErrCB: disconnectedError,
-		Name:              c.ConnId + "::" + opts.Username,
+		Url:            url,
+		Servers:        opts.serverUrls(),
+		AllowReconnect: opts.Reconnect,
+		MaxReconnect:   opts.MaxReconnect,
+		ReconnectWait:  opts.ReconnectInterval,
+		Timeout:        opts.Timeout,
+		Token:          opts.ConnectionToken,
+		PingInterval:   opts.PingInterval,
+		MaxPingsOut:    opts.MaxPingsOut,
+		DisconnectedErrCB: func(conn *nats.Conn, err error) {
+			disconnectedError(conn, err)
+			c.emitEvent(ConnEvent{Type: ConnEventDisconnected, Err: err})
+		},
+		ReconnectedCB: func(*nats.Conn) {
+			c.emitEvent(ConnEvent{Type: ConnEventReconnected})
+		},
+		ClosedCB: func(*nats.Conn) {
+			c.emitEvent(ConnEvent{Type: ConnEventClosed})
+			c.closeEvents()
+		},
+		// the broker parses the name as "<connection id>::<username>", so it can't carry a custom label
+		Name:        c.ConnId + "::" + opts.Username,
+		InboxPrefix: opts.InboxPrefix,
 	}
 	if (opts.TLSOpts.TlsCert != "") || (opts.TLSOpts.TlsKey != "") || (opts.TLSOpts.CaFile != "") {
 		if opts.TLSOpts.TlsCert == "" {
@@ -239,7 +489,7 @@ func (c *Conn) startConn() error {
 		if err != nil {
 			return memphisError(errors.New("memphis: error parsing client certificate: " + err.Error()))
 		}
-		TLSConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		TLSConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: opts.TLSServerName}
 		TLSConfig.Certificates = []tls.Certificate{cert}
 		certs := x509.NewCertPool()
 
@@ -250,25 +500,271 @@ func (c *Conn) startConn() error {
 		certs.AppendCertsFromPEM(pemData)
 		TLSConfig.RootCAs = certs
 		natsOpts.TLSConfig = TLSConfig
+	} else if opts.TLSServerName != "" {
+		return memphisError(errors.New("TLS server name is set but TLS isn't configured, use Tls"))
 	}
 
+	installTestDialer(&natsOpts)
+
 	c.brokerConn, err = natsOpts.Connect()
 	if err != nil {
 		return memphisError(err)
 	}
-	c.js, err = c.brokerConn.JetStream()
+	c.jsAPIPrefix = defaultJsAPIPrefix
+	c.js, err = c.brokerConn.JetStream(nats.APIPrefix(c.jsAPIPrefix))
 
 	if err != nil {
 		c.brokerConn.Close()
 		return memphisError(err)
 	}
 	c.username = opts.Username
+	c.maxPayload = c.brokerConn.MaxPayload()
 	return nil
 }
 
 func (c *Conn) Close() {
+	c.destroyStationProducers()
 	c.brokerConn.Close()
 	c.setProducersMap(nil)
+	c.closeAudit()
+}
+
+// ConnEventType - type of a connection event.
+type ConnEventType int
+
+const (
+	ConnEventConnected ConnEventType = iota
+	ConnEventDisconnected
+	ConnEventReconnected
+	ConnEventSchemaUpdated
+	ConnEventClosed
+)
+
+func (t ConnEventType) String() string {
+	return [...]string{"connected", "disconnected", "reconnected", "schema_updated", "closed"}[t]
+}
+
+// ConnEvent - a connection state change, StationName and SchemaUpdate are set for schema updates
+// and Err for disconnections caused by an error.
+type ConnEvent struct {
+	Type         ConnEventType
+	Time         time.Time
+	StationName  string
+	SchemaUpdate SchemaUpdate
+	Err          error
+}
+
+// Conn.Events - get a channel of the connection's state changes, the channel is closed once the connection is closed.
+// The channel is buffered, events are dropped when it is full and counted by DroppedEvents.
+func (c *Conn) Events() <-chan ConnEvent {
+	return c.events
+}
+
+// Conn.DroppedEvents - get the number of connection events dropped because the events channel was full.
+func (c *Conn) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&c.droppedEvents)
+}
+
+func (c *Conn) emitEvent(event ConnEvent) {
+	event.Time = time.Now()
+
+	c.eventsMu.RLock()
+	defer c.eventsMu.RUnlock()
+	if c.eventsClosed {
+		return
+	}
+	select {
+	case c.events <- event:
+	default:
+		atomic.AddUint64(&c.droppedEvents, 1)
+	}
+}
+
+func (c *Conn) closeEvents() {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+	if !c.eventsClosed {
+		c.eventsClosed = true
+		close(c.events)
+	}
+}
+
+// AuditSink - records every message produced and consumed through a connection, for a durable audit trail.
+// Methods are called from a single goroutine, in the order the messages were produced and consumed.
+type AuditSink interface {
+	RecordProduce(ProduceAuditEvent)
+	RecordConsume(ConsumeAuditEvent)
+}
+
+// ProduceAuditEvent - a message published by a producer, MsgId is the message's msg-id header if it has one.
+type ProduceAuditEvent struct {
+	Username     string
+	ProducerName string
+	StationName  string
+	MsgId        string
+	Size         int
+	Time         time.Time
+}
+
+// ConsumeAuditEvent - a message fetched by a consumer, MsgId is the message's msg-id header if it has one.
+type ConsumeAuditEvent struct {
+	Username      string
+	ConsumerName  string
+	ConsumerGroup string
+	StationName   string
+	MsgId         string
+	Sequence      uint64
+	Size          int
+	Time          time.Time
+}
+
+// Conn.DroppedAuditEvents - get the number of audit events dropped because the audit sink didn't keep up.
+func (c *Conn) DroppedAuditEvents() uint64 {
+	return atomic.LoadUint64(&c.droppedAuditEvents)
+}
+
+func (c *Conn) startAudit(sink AuditSink) {
+	c.auditEvents = make(chan any, auditEventsBufferSize)
+	c.auditDone = make(chan struct{})
+	go func() {
+		defer close(c.auditDone)
+		for event := range c.auditEvents {
+			switch event := event.(type) {
+			case ProduceAuditEvent:
+				sink.RecordProduce(event)
+			case ConsumeAuditEvent:
+				sink.RecordConsume(event)
+			}
+		}
+	}()
+}
+
+// Conn.recordAudit - hands event to the audit sink without blocking, the event is dropped if the buffer is full.
+func (c *Conn) recordAudit(event any) {
+	c.auditMu.RLock()
+	defer c.auditMu.RUnlock()
+	if c.auditEvents == nil || c.auditClosed {
+		return
+	}
+	select {
+	case c.auditEvents <- event:
+	default:
+		atomic.AddUint64(&c.droppedAuditEvents, 1)
+	}
+}
+
+func (c *Conn) auditProduce(p *Producer, msg *nats.Msg) {
+	if c == nil || c.auditEvents == nil {
+		return
+	}
+	c.recordAudit(ProduceAuditEvent{
+		Username:     c.username,
+		ProducerName: p.Name,
+		StationName:  p.stationName,
+		MsgId:        firstHeaderValue(msg.Header, "msg-id"),
+		Size:         len(msg.Data),
+		Time:         time.Now(),
+	})
+}
+
+func (c *Conn) auditConsume(consumer *Consumer, msg *Msg) {
+	if c == nil || c.auditEvents == nil {
+		return
+	}
+	c.recordAudit(ConsumeAuditEvent{
+		Username:      c.username,
+		ConsumerName:  consumer.Name,
+		ConsumerGroup: consumer.ConsumerGroup,
+		StationName:   consumer.stationName,
+		MsgId:         firstHeaderValue(msg.msg.Header, "msg-id"),
+		Sequence:      msg.StreamSequence(),
+		Size:          len(msg.msg.Data),
+		Time:          time.Now(),
+	})
+}
+
+// Conn.closeAudit - stops accepting audit events and waits for the buffered ones to be recorded.
+func (c *Conn) closeAudit() {
+	c.auditMu.Lock()
+	if c.auditEvents == nil || c.auditClosed {
+		c.auditMu.Unlock()
+		return
+	}
+	c.auditClosed = true
+	close(c.auditEvents)
+	c.auditMu.Unlock()
+	<-c.auditDone
+}
+
+func firstHeaderValue(header nats.Header, key string) string {
+	if values := header[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c *Conn) trackProducer(p *Producer) {
+	c.entitiesMu.Lock()
+	defer c.entitiesMu.Unlock()
+	c.producers[p] = struct{}{}
+}
+
+func (c *Conn) untrackProducer(p *Producer) {
+	c.entitiesMu.Lock()
+	defer c.entitiesMu.Unlock()
+	delete(c.producers, p)
+}
+
+func (c *Conn) trackConsumer(consumer *Consumer) {
+	c.entitiesMu.Lock()
+	defer c.entitiesMu.Unlock()
+	c.consumers[consumer] = struct{}{}
+}
+
+func (c *Conn) untrackConsumer(consumer *Consumer) {
+	c.entitiesMu.Lock()
+	defer c.entitiesMu.Unlock()
+	delete(c.consumers, consumer)
+}
+
+// Conn.isReconnecting - whether err is caused by the connection to the broker being re-established.
+func (c *Conn) isReconnecting(err error) bool {
+	return errors.Is(err, nats.ErrConnectionReconnecting) || (c.brokerConn != nil && c.brokerConn.IsReconnecting())
+}
+
+// Conn.DestroyAll - concurrently destroys all the producers and consumers created by this connection,
+// returns the aggregated errors or a timeout error if not all were destroyed within timeout.
+func (c *Conn) DestroyAll(timeout time.Duration) error {
+	c.entitiesMu.Lock()
+	destroyFuncs := make([]func() error, 0, len(c.producers)+len(c.consumers))
+	for p := range c.producers {
+		destroyFuncs = append(destroyFuncs, p.Destroy)
+	}
+	for consumer := range c.consumers {
+		destroyFuncs = append(destroyFuncs, consumer.Destroy)
+	}
+	c.entitiesMu.Unlock()
+
+	errs := make(chan error, len(destroyFuncs))
+	for _, destroy := range destroyFuncs {
+		go func(destroy func() error) {
+			errs <- destroy()
+		}(destroy)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var destroyErrs []error
+	for range destroyFuncs {
+		select {
+		case err := <-errs:
+			destroyErrs = append(destroyErrs, err)
+		case <-timer.C:
+			destroyErrs = append(destroyErrs, errors.New("destroy timed out"))
+			return memphisError(joinErrors(destroyErrs...))
+		}
+	}
+	return memphisError(joinErrors(destroyErrs...))
 }
 
 func (c *Conn) brokerCorePublish(subject, reply string, msg []byte) error {
@@ -283,6 +779,56 @@ func (c *Conn) brokerPullSubscribe(subject, durable string, opts ...nats.SubOpt)
 	return c.js.PullSubscribe(subject, durable, opts...)
 }
 
+func (c *Conn) brokerStreamInfo(stream string) (*nats.StreamInfo, error) {
+	return c.js.StreamInfo(stream)
+}
+
+type streamNamesReq struct {
+	Offset int `json:"offset,omitempty"`
+}
+
+type streamNamesResp struct {
+	Total   int            `json:"total"`
+	Streams []string       `json:"streams"`
+	Error   *nats.APIError `json:"error,omitempty"`
+}
+
+// Conn.brokerStreamNames - lists the broker's streams page by page, unlike JetStreamContext.StreamNames
+// a failed listing is returned instead of ending the list early.
+func (c *Conn) brokerStreamNames() ([]string, error) {
+	var names []string
+	for {
+		b, err := c.marshalJSON(streamNamesReq{Offset: len(names)})
+		if err != nil {
+			return nil, err
+		}
+		msg, err := c.brokerConn.Request(c.jsAPISubject("STREAM.NAMES"), b, c.opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		var resp streamNamesResp
+		if err := c.unmarshalJSON(msg.Data, &resp); err != nil {
+			return nil, err
+		}
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		names = append(names, resp.Streams...)
+		if len(resp.Streams) == 0 || len(names) >= resp.Total {
+			return names, nil
+		}
+	}
+}
+
+// Conn.jsAPISubject - the subject of a JetStream API request, under the API prefix the connection's JetStream context uses.
+func (c *Conn) jsAPISubject(api string) string {
+	prefix := c.jsAPIPrefix
+	if prefix == "" {
+		prefix = defaultJsAPIPrefix
+	}
+	return prefix + api
+}
+
 func (c *Conn) brokerQueueSubscribe(subj, queue string, cb nats.MsgHandler) (*nats.Subscription, error) {
 	return c.brokerConn.QueueSubscribe(subj, queue, cb)
 }
@@ -327,7 +873,8 @@ func ReconnectInterval(reconnectInterval time.Duration) Option {
 	}
 }
 
-// Timeout - connection timeout in miliseconds.
+// Timeout - how long to wait for each connection attempt, to the host and to each of the servers
+// given with WithServers, before failing over to the next one or failing Connect, default is 15 seconds.
 func Timeout(timeout time.Duration) Option {
 	return func(o *Options) error {
 		o.Timeout = timeout
@@ -335,6 +882,262 @@ func Timeout(timeout time.Duration) Option {
 	}
 }
 
+// WithConnectTimeout - same as Timeout, but fails Connect for a timeout that isn't positive.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(o *Options) error {
+		if d <= 0 {
+			return errors.New("connect timeout must be positive")
+		}
+		return Timeout(d)(o)
+	}
+}
+
+// WithServers - additional broker hosts to connect to and fail over to on reconnect, hosts without a port use the connection's port.
+func WithServers(servers []string) Option {
+	return func(o *Options) error {
+		o.Servers = append(o.Servers, servers...)
+		return nil
+	}
+}
+
+// WithAuditSink - record every message produced and consumed through the connection with sink. Events are handed to
+// the sink asynchronously through a buffer of 1024 events, events are dropped when the buffer is full so a slow sink
+// never blocks producing or consuming (see Conn.DroppedAuditEvents). Close waits for the buffered events to be recorded.
+// Produce events are recorded once the message is published, for async produces before the broker acks it,
+// consume events once the message is fetched, before it's handled.
+func WithAuditSink(sink AuditSink) Option {
+	return func(o *Options) error {
+		if sink == nil {
+			return errors.New("audit sink can't be nil")
+		}
+		o.AuditSink = sink
+		return nil
+	}
+}
+
+// WithJSONMarshaler - JSON marshal function used for control messages sent to the broker and for encoding messages as JSON,
+// default is json.Marshal.
+func WithJSONMarshaler(marshal JSONMarshalFunc) Option {
+	return func(o *Options) error {
+		if marshal == nil {
+			return errors.New("json marshaler can not be nil")
+		}
+		o.JSONMarshaler = marshal
+		return nil
+	}
+}
+
+// WithJSONUnmarshaler - JSON unmarshal function used for control messages received from the broker and for decoding JSON messages,
+// default is json.Unmarshal.
+func WithJSONUnmarshaler(unmarshal JSONUnmarshalFunc) Option {
+	return func(o *Options) error {
+		if unmarshal == nil {
+			return errors.New("json unmarshaler can not be nil")
+		}
+		o.JSONUnmarshaler = unmarshal
+		return nil
+	}
+}
+
+// WithPingInterval - interval between keepalive pings sent to the broker, default is 2 minutes.
+// A shorter interval keeps idle connections alive behind load balancers/NATs with aggressive idle timeouts.
+func WithPingInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval <= 0 {
+			return errors.New("ping interval has to be a positive duration")
+		}
+		o.PingInterval = interval
+		return nil
+	}
+}
+
+// WithMaxPingsOut - max number of unanswered pings before the connection is considered stale, default is 2.
+func WithMaxPingsOut(maxPingsOut int) Option {
+	return func(o *Options) error {
+		if maxPingsOut <= 0 {
+			return errors.New("max pings out has to be a positive number")
+		}
+		o.MaxPingsOut = maxPingsOut
+		return nil
+	}
+}
+
+// WithSchemaFetchTimeout - bounds fetching stations' schemas, ErrSchemaFetchTimeout is returned when it elapses.
+// The schema is returned by the broker in reply to the producer creation request, which waits up to d instead
+// of 5 seconds and fails the creation on timeout. Subscriptions to the station's schema updates, made when
+// a producer is created or on its first produce with WithLazySchema, wait up to d for the broker's confirmation
+// and fail unless the fallback is SchemaFetchRawBytes. By default producers don't wait for the confirmation.
+func WithSchemaFetchTimeout(d time.Duration) Option {
+	return func(o *Options) error {
+		if d <= 0 {
+			return errors.New("schema fetch timeout has to be a positive duration")
+		}
+		o.SchemaFetchTimeout = d
+		return nil
+	}
+}
+
+// WithSchemaFetchFallback - what producers do when the schema fetch timeout elapses, default is SchemaFetchFail.
+func WithSchemaFetchFallback(policy SchemaFetchPolicy) Option {
+	return func(o *Options) error {
+		if policy != SchemaFetchFail && policy != SchemaFetchRawBytes {
+			return fmt.Errorf("unknown schema fetch policy %v", policy)
+		}
+		o.SchemaFetchFallback = policy
+		return nil
+	}
+}
+
+// WithSchemaFetchRetry - retry subscribing to a station's schema updates up to attempts times in total on transient
+// failures (a broker that doesn't confirm the subscription in time or a connection that is reconnecting), waiting
+// backoff(attempt) between attempts. Retries are bounded by the schema fetch timeout, each attempt waits up to
+// the timeout divided by attempts for the confirmation. Default is 3 attempts with an exponential backoff from 50ms.
+func WithSchemaFetchRetry(attempts int, backoff BackoffStrategy) Option {
+	return func(o *Options) error {
+		if attempts <= 0 {
+			return errors.New("schema fetch attempts has to be a positive number")
+		}
+		if backoff == nil {
+			return errors.New("schema fetch backoff can't be nil")
+		}
+		o.SchemaFetchAttempts = attempts
+		o.SchemaFetchBackoff = backoff
+		return nil
+	}
+}
+
+// WithMaxConcurrentSchemaOps - limit the number of stations' schemas subscribed to and compiled at the same time by
+// this connection to n, smoothing the load when many producers are created at once, e.g. on startup. Producers waiting
+// for a slot wait as part of their creation (or first produce with WithLazySchema), the wait doesn't count towards the
+// schema fetch timeout. Default is no limit.
+func WithMaxConcurrentSchemaOps(n int) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return errors.New("max concurrent schema operations has to be a positive number")
+		}
+		o.MaxConcurrentSchemaOps = n
+		return nil
+	}
+}
+
+// WithUnknownSchemaPassthrough - produce []byte messages as is, without validation, to stations whose schema type
+// isn't supported by this client version. By default producing to such stations fails with ErrUnsupportedSchemaType.
+func WithUnknownSchemaPassthrough() Option {
+	return func(o *Options) error {
+		o.UnknownSchemaPassthrough = true
+		return nil
+	}
+}
+
+// WithInboxPrefix - the subject prefix of the inboxes used for request/reply (creations, destructions, schema fetches
+// and Producer.Request replies) instead of _INBOX, for NATS accounts where _INBOX is restricted. The prefix is made
+// of subject tokens separated by dots, without wildcards or whitespace. Produce acks keep using _INBOX, the
+// NATS client this version is built on doesn't apply the prefix to JetStream publish acks.
+func WithInboxPrefix(prefix string) Option {
+	return func(o *Options) error {
+		if err := validateInboxPrefix(prefix); err != nil {
+			return err
+		}
+		o.InboxPrefix = prefix
+		return nil
+	}
+}
+
+func validateInboxPrefix(prefix string) error {
+	if prefix == "" {
+		return errors.New("inbox prefix can not be empty")
+	}
+	for _, token := range strings.Split(prefix, ".") {
+		if err := validateSubjectToken(token); err != nil {
+			return fmt.Errorf("inbox prefix %q: %w", prefix, err)
+		}
+	}
+	return nil
+}
+
+// Conn.confirmSchemaListener - waits up to timeout for the broker to process the schema updates subscription
+// of the connection, it isn't confirmed if timeout isn't positive.
+func (c *Conn) confirmSchemaListener(timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+	err := c.brokerConn.FlushTimeout(timeout)
+	if errors.Is(err, nats.ErrTimeout) {
+		return fmt.Errorf("%w after %v", ErrSchemaFetchTimeout, timeout)
+	}
+	return err
+}
+
+func isTransientSchemaFetchErr(err error) bool {
+	return errors.Is(err, ErrSchemaFetchTimeout) || errors.Is(err, nats.ErrConnectionReconnecting)
+}
+
+// WithProduceSubjectSuffix - override the suffix added to the station's internal name to get the subject messages
+// are produced to, default is ".final". Meant for non-standard broker setups and tests, consumers still consume
+// from the ".final" subject. The suffix has to be a '.' followed by a single subject token.
+func WithProduceSubjectSuffix(suffix string) Option {
+	return func(o *Options) error {
+		if err := validateSubjectSuffix(suffix); err != nil {
+			return err
+		}
+		o.ProduceSubjectSuffix = suffix
+		return nil
+	}
+}
+
+func validateSubjectSuffix(suffix string) error {
+	token := strings.TrimPrefix(suffix, ".")
+	if token == suffix {
+		return fmt.Errorf("subject suffix %q has to be a '.' followed by a subject token", suffix)
+	}
+	if err := validateSubjectToken(token); err != nil {
+		return fmt.Errorf("subject suffix %q: %w", suffix, err)
+	}
+	return nil
+}
+
+// validateSubjectToken - verifies token is a single, non wildcard, subject token.
+func validateSubjectToken(token string) error {
+	if token == "" {
+		return errors.New("subject token can't be empty")
+	}
+	if strings.ContainsAny(token, ".*> \t\r\n") {
+		return fmt.Errorf("%q contains characters not allowed in a subject token", token)
+	}
+	return nil
+}
+
+// Conn.produceSubject - the subject messages of a station are produced to.
+func (c *Conn) produceSubject(stationName string) string {
+	suffix := c.opts.ProduceSubjectSuffix
+	if suffix == "" {
+		suffix = defaultProduceSubjectSuffix
+	}
+	return getInternalName(stationName) + suffix
+}
+
+// MaxHeadersSize - max total size in bytes of a message's header keys and values, default is 64KB.
+func MaxHeadersSize(maxHeadersSize int) Option {
+	return func(o *Options) error {
+		if maxHeadersSize <= 0 {
+			return errors.New("max headers size has to be a positive number")
+		}
+		o.MaxHeadersSize = maxHeadersSize
+		return nil
+	}
+}
+
+// MaxHeadersCount - max number of header values in a message, default is 256.
+func MaxHeadersCount(maxHeadersCount int) Option {
+	return func(o *Options) error {
+		if maxHeadersCount <= 0 {
+			return errors.New("max headers count has to be a positive number")
+		}
+		o.MaxHeadersCount = maxHeadersCount
+		return nil
+	}
+}
+
 // Tls - paths to tls cert, key and ca files.
 func Tls(TlsCert string, TlsKey string, CaFile string) Option {
 	return func(o *Options) error {
@@ -347,6 +1150,18 @@ func Tls(TlsCert string, TlsKey string, CaFile string) Option {
 	}
 }
 
+// WithTLSServerName - the name the broker's certificate is verified against instead of the dialed host,
+// e.g. when connecting through a load balancer whose hostname isn't in the certificate. Requires Tls.
+func WithTLSServerName(name string) Option {
+	return func(o *Options) error {
+		if name == "" {
+			return errors.New("TLS server name can not be empty")
+		}
+		o.TLSServerName = name
+		return nil
+	}
+}
+
 type directObj interface {
 	getCreationSubject() string
 	getCreationReq() any
@@ -363,15 +1178,20 @@ func defaultHandleCreationResp(resp []byte) error {
 }
 
 func (c *Conn) create(do directObj) error {
+	return c.createWithTimeout(do, 5*time.Second)
+}
+
+// Conn.createWithTimeout - sends the creation request of do and waits up to timeout for the broker's reply.
+func (c *Conn) createWithTimeout(do directObj, timeout time.Duration) error {
 	subject := do.getCreationSubject()
 	req := do.getCreationReq()
 
-	b, err := json.Marshal(req)
+	b, err := c.marshalJSON(req)
 	if err != nil {
 		return memphisError(err)
 	}
 
-	msg, err := c.brokerConn.Request(subject, b, 5*time.Second)
+	msg, err := c.brokerConn.Request(subject, b, timeout)
 	if err != nil {
 		return memphisError(err)
 	}
@@ -388,7 +1208,7 @@ func (c *Conn) AttachSchema(name string, stationName string) error {
 		Username:    c.username,
 	}
 
-	b, err := json.Marshal(creationReq)
+	b, err := c.marshalJSON(creationReq)
 	if err != nil {
 		return memphisError(err)
 	}
@@ -411,7 +1231,7 @@ func (c *Conn) DetachSchema(stationName string) error {
 		Username:    c.username,
 	}
 
-	b, err := json.Marshal(req)
+	b, err := c.marshalJSON(req)
 	if err != nil {
 		return memphisError(err)
 	}
@@ -430,7 +1250,7 @@ func (c *Conn) destroy(o directObj) error {
 	subject := o.getDestructionSubject()
 	destructionReq := o.getDestructionReq()
 
-	b, err := json.Marshal(destructionReq)
+	b, err := c.marshalJSON(destructionReq)
 	if err != nil {
 		return memphisError(err)
 	}
@@ -451,6 +1271,36 @@ func getInternalName(name string) string {
 	return replaceDelimiters(name)
 }
 
+// InternalName - returns the name a station is known by internally, as used in the broker's subjects.
+func InternalName(name string) string {
+	return getInternalName(name)
+}
+
+// Conn.InternalStationName - returns the internal name of a station, the same one used to build its subjects.
+func (c *Conn) InternalStationName(name string) string {
+	return getInternalName(name)
+}
+
+// validateName - verifies a station/producer name is accepted by the broker, names are lowercased before validation.
+func validateName(name, objType string) error {
+	if name == "" {
+		return fmt.Errorf("%w: %v name can not be empty", ErrInvalidName, objType)
+	}
+	if len(name) > maxNameLength {
+		return fmt.Errorf("%w: %v name %q exceeds %v characters", ErrInvalidName, objType, name, maxNameLength)
+	}
+	for _, ch := range name {
+		if (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == '-' || ch == '.' {
+			continue
+		}
+		if ch >= 'A' && ch <= 'Z' {
+			return fmt.Errorf("%w: %v name %q contains uppercase character %q, the broker lowercases names, use %q (see InternalName)", ErrInvalidName, objType, name, ch, strings.ToLower(name))
+		}
+		return fmt.Errorf("%w: %v name %q contains invalid character %q", ErrInvalidName, objType, name, ch)
+	}
+	return nil
+}
+
 const (
 	delimToReplace   = "."
 	delimReplacement = "#"
@@ -470,7 +1320,7 @@ func (c *Conn) listenToConfigurationUpdates() error {
 
 	go cus.configurationsUpdatesHandler(&c.configUpdatesMu)
 	var err error
-	cus.ConfigUpdateSub, err = c.brokerConn.Subscribe(configurationUpdatesSubject, cus.createUpdatesHandler())
+	cus.ConfigUpdateSub, err = c.brokerConn.Subscribe(configurationUpdatesSubject, cus.createUpdatesHandler(c.opts.JSONUnmarshaler))
 	if err != nil {
 		close(cus.ConfigUpdatesCh)
 		return memphisError(err)
@@ -479,10 +1329,10 @@ func (c *Conn) listenToConfigurationUpdates() error {
 	return nil
 }
 
-func (cus *configurationsUpdateSub) createUpdatesHandler() nats.MsgHandler {
+func (cus *configurationsUpdateSub) createUpdatesHandler(unmarshal JSONUnmarshalFunc) nats.MsgHandler {
 	return func(msg *nats.Msg) {
 		var update ConfigurationsUpdate
-		err := json.Unmarshal(msg.Data, &update)
+		err := unmarshalJSON(unmarshal, msg.Data, &update)
 		if err != nil {
 			log.Printf("schema update unmarshal error: %v\n", memphisError(err))
 			return
@@ -1,7 +1,16 @@
 package memphis
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
 )
 
 func TestConnect(t *testing.T) {
@@ -13,6 +22,20 @@ func TestConnect(t *testing.T) {
 	c.Close()
 }
 
+func TestValidateConnection(t *testing.T) {
+	err := ValidateConnection("localhost", "root", "memphis", []string{"station_name_missing_a", "station_name_missing_b"})
+	if !errors.Is(err, ErrStationNotFound) {
+		t.Errorf("expected ErrStationNotFound, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "station_name_missing_b") {
+		t.Errorf("expected each station to be reported, got %v", err)
+	}
+
+	if err := ValidateConnection("localhost", "root", "wrong-token", nil); err == nil {
+		t.Error("expected error for invalid credentials")
+	}
+}
+
 func TestNormalizeHost(t *testing.T) {
 	if "www.google.com" != normalizeHost("http://www.google.com") {
 		t.Error()
@@ -51,3 +74,405 @@ func TestProduceNoProducer(t *testing.T) {
 		t.Error("unsetStationProducers failed to remove key [station_name_c_produce]")
 	}
 }
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"station_name_1", "station.name-2"} {
+		if err := validateName(name, "station"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, name := range []string{"", "station name", "station$name", "Station.Name-2", strings.Repeat("a", maxNameLength+1)} {
+		err := validateName(name, "station")
+		if !errors.Is(memphisError(err), ErrInvalidName) {
+			t.Errorf("expected ErrInvalidName for %q, got %v", name, err)
+		}
+	}
+	if err := validateName("Station.Name-2", "station"); !strings.Contains(err.Error(), "uppercase character 'S'") || !strings.Contains(err.Error(), `"station.name-2"`) {
+		t.Errorf("expected the uppercase character and the lowercased name to be reported, got %v", err)
+	}
+
+	if InternalName("Station.Name") != "station#name" {
+		t.Error("unexpected internal name")
+	}
+}
+
+func TestRecordProduce(t *testing.T) {
+	c := &Conn{}
+	c.recordProduce(nil)
+	c.recordProduce(errors.New("produce failed"))
+	if c.produces != 2 || c.produceFailures != 1 {
+		t.Errorf("unexpected counters: produces %v, failures %v", c.produces, c.produceFailures)
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	if joinErrors(nil, nil) != nil {
+		t.Error("expected nil for no errors")
+	}
+
+	errA := errors.New("a")
+	err := joinErrors(errA, nil, errors.New("b"))
+	if err.Error() != "a; b" {
+		t.Errorf("unexpected error message: %v", err)
+	}
+	if !errors.Is(err, errA) {
+		t.Error("joined error should wrap its errors")
+	}
+}
+
+func TestServerUrls(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.Servers = []string{"https://memphis-1", "memphis-2:7777"}
+	urls := opts.serverUrls()
+	if len(urls) != 2 || urls[0] != "memphis-1:6666" || urls[1] != "memphis-2:7777" {
+		t.Errorf("unexpected server urls: %v", urls)
+	}
+}
+
+func TestJSONMarshalerOptions(t *testing.T) {
+	var marshals, unmarshals int
+	opts := getDefaultOptions()
+	marshal := func(v any) ([]byte, error) {
+		marshals++
+		return json.Marshal(v)
+	}
+	unmarshal := func(data []byte, v any) error {
+		unmarshals++
+		return json.Unmarshal(data, v)
+	}
+	for _, opt := range []Option{WithJSONMarshaler(marshal), WithJSONUnmarshaler(unmarshal)} {
+		if err := opt(&opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WithJSONMarshaler(nil)(&opts); err == nil {
+		t.Error("expected error for a nil marshaler")
+	}
+	c := &Conn{opts: opts}
+
+	p := Producer{conn: c}
+	if _, err := p.rawMsgBytes(map[string]interface{}{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if marshals != 1 {
+		t.Errorf("expected the connection's marshaler to encode maps, got %v calls", marshals)
+	}
+
+	err := c.InjectSchema("station_name_a", SchemaUpdateInit{SchemaType: "json", ActiveVersion: SchemaVersion{Content: `{"type": "object"}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ValidateMsg("station_name_a", []byte(`{"id": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if unmarshals != 1 {
+		t.Errorf("expected the connection's unmarshaler to be used by schema validation, got %v calls", unmarshals)
+	}
+
+	var decoded map[string]interface{}
+	msg := Msg{msg: &nats.Msg{Data: []byte(`{"id": 1}`)}, conn: c}
+	if err := msg.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if unmarshals != 2 {
+		t.Errorf("expected the connection's unmarshaler to decode messages, got %v calls", unmarshals)
+	}
+}
+
+func TestSchemaListenersResubscribeOnReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	subs := make(chan string, 10)
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go func(conn net.Conn) {
+				defer conn.Close()
+				fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.9.0\",\"headers\":true,\"max_payload\":1048576}\r\n")
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					if len(fields) == 0 {
+						continue
+					}
+					switch fields[0] {
+					case "PING":
+						fmt.Fprint(conn, "PONG\r\n")
+					case "SUB":
+						subs <- fields[1]
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	nc, err := nats.Connect("nats://"+l.Addr().String(), nats.ReconnectWait(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	c := &Conn{brokerConn: nc, stationUpdatesSubs: make(map[string]*stationUpdateSub), opts: getDefaultOptions()}
+	if err := c.listenToSchemaUpdates("station_name", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	subject := fmt.Sprintf(schemaUpdatesSubjectTemplate, getInternalName("station_name"))
+	waitForSub := func(when string) {
+		select {
+		case s := <-subs:
+			if s != subject {
+				t.Fatalf("expected a subscription to %v %v, got %v", subject, when, s)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the schema updates listener wasn't subscribed %v", when)
+		}
+	}
+	waitForSub("on creation")
+	(<-conns).Close()
+	waitForSub("after reconnecting")
+}
+
+func TestConnEvents(t *testing.T) {
+	c := &Conn{events: make(chan ConnEvent, 2)}
+	c.emitEvent(ConnEvent{Type: ConnEventConnected})
+	c.emitEvent(ConnEvent{Type: ConnEventDisconnected, Err: errors.New("eof")})
+	c.emitEvent(ConnEvent{Type: ConnEventReconnected})
+	if dropped := c.DroppedEvents(); dropped != 1 {
+		t.Errorf("expected 1 dropped event, got %v", dropped)
+	}
+
+	c.closeEvents()
+	c.closeEvents()
+	c.emitEvent(ConnEvent{Type: ConnEventClosed})
+
+	var types []ConnEventType
+	for event := range c.Events() {
+		if event.Time.IsZero() {
+			t.Error("events should be timestamped")
+		}
+		types = append(types, event.Type)
+	}
+	if len(types) != 2 || types[0] != ConnEventConnected || types[1] != ConnEventDisconnected {
+		t.Errorf("unexpected events %v", types)
+	}
+}
+
+func TestWithProduceSubjectSuffix(t *testing.T) {
+	c := &Conn{opts: getDefaultOptions()}
+	if subj := c.produceSubject("station.a"); subj != "station#a.final" {
+		t.Errorf("unexpected default subject %v", subj)
+	}
+
+	for _, suffix := range []string{"", "final", ".", ".a.b", ".a*", ".>", ". a"} {
+		if err := WithProduceSubjectSuffix(suffix)(&c.opts); err == nil {
+			t.Errorf("expected error for suffix %q", suffix)
+		}
+	}
+	if err := WithProduceSubjectSuffix(".test")(&c.opts); err != nil {
+		t.Fatal(err)
+	}
+	if subj := c.produceSubject("station.a"); subj != "station#a.test" {
+		t.Errorf("unexpected subject %v", subj)
+	}
+}
+
+func TestSchemaFetchOptions(t *testing.T) {
+	c := &Conn{opts: getDefaultOptions()}
+	if err := c.confirmSchemaListener(c.opts.SchemaFetchTimeout); err != nil {
+		t.Errorf("without a timeout the listener shouldn't be confirmed, got %v", err)
+	}
+	if err := WithSchemaFetchTimeout(0)(&c.opts); err == nil {
+		t.Error("expected error for a non positive timeout")
+	}
+	if err := WithSchemaFetchFallback(SchemaFetchPolicy(5))(&c.opts); err == nil {
+		t.Error("expected error for an unknown policy")
+	}
+
+	timeoutErr := fmt.Errorf("%w after 1s", ErrSchemaFetchTimeout)
+	if c.fallBackToRawBytes(timeoutErr) {
+		t.Error("producers should fail by default")
+	}
+	if err := WithSchemaFetchFallback(SchemaFetchRawBytes)(&c.opts); err != nil {
+		t.Fatal(err)
+	}
+	if !c.fallBackToRawBytes(timeoutErr) {
+		t.Error("producers should fall back to raw bytes on a timeout")
+	}
+	if c.fallBackToRawBytes(errors.New("subscribe failed")) {
+		t.Error("producers should fall back only on a timeout")
+	}
+}
+
+func TestWithConnectTimeout(t *testing.T) {
+	opts := getDefaultOptions()
+	if opts.Timeout != defaultConnectTimeout {
+		t.Errorf("unexpected default timeout %v", opts.Timeout)
+	}
+	if opts.Timeout != 15*time.Second {
+		t.Errorf("expected a 15 seconds default, got %v", opts.Timeout)
+	}
+	if err := WithConnectTimeout(0)(&opts); err == nil {
+		t.Error("expected error for a non positive timeout")
+	}
+	if err := Timeout(0)(&opts); err != nil {
+		t.Errorf("Timeout shouldn't validate the timeout, got %v", err)
+	}
+	if err := WithConnectTimeout(time.Second)(&opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Timeout != time.Second {
+		t.Errorf("unexpected timeout %v", opts.Timeout)
+	}
+}
+
+type testAuditSink struct {
+	produces []ProduceAuditEvent
+	consumes []ConsumeAuditEvent
+	block    chan struct{}
+}
+
+func (s *testAuditSink) RecordProduce(event ProduceAuditEvent) {
+	if s.block != nil {
+		<-s.block
+	}
+	s.produces = append(s.produces, event)
+}
+
+func (s *testAuditSink) RecordConsume(event ConsumeAuditEvent) {
+	s.consumes = append(s.consumes, event)
+}
+
+func TestAuditSink(t *testing.T) {
+	if err := WithAuditSink(nil)(&Options{}); err == nil {
+		t.Error("expected error for a nil sink")
+	}
+
+	sink := &testAuditSink{}
+	c := &Conn{username: "root"}
+	c.startAudit(sink)
+	p := &Producer{Name: "producer_a", stationName: "station_a"}
+	c.auditProduce(p, &nats.Msg{Header: nats.Header{"msg-id": []string{"id-1"}}, Data: []byte("hey")})
+	consumer := &Consumer{Name: "consumer_a", ConsumerGroup: "group_a", stationName: "station_a"}
+	c.auditConsume(consumer, &Msg{msg: &nats.Msg{Header: nats.Header{}, Data: []byte("hey there")}})
+	c.closeAudit()
+	c.closeAudit()
+	c.auditProduce(p, &nats.Msg{})
+
+	if len(sink.produces) != 1 || len(sink.consumes) != 1 {
+		t.Fatalf("expected the buffered events to be recorded once, got %v produces and %v consumes", len(sink.produces), len(sink.consumes))
+	}
+	produce := sink.produces[0]
+	if produce.Username != "root" || produce.ProducerName != "producer_a" || produce.StationName != "station_a" || produce.MsgId != "id-1" || produce.Size != 3 || produce.Time.IsZero() {
+		t.Errorf("unexpected produce event %+v", produce)
+	}
+	consume := sink.consumes[0]
+	if consume.ConsumerGroup != "group_a" || consume.MsgId != "" || consume.Size != 9 {
+		t.Errorf("unexpected consume event %+v", consume)
+	}
+
+	slow := &testAuditSink{block: make(chan struct{})}
+	c = &Conn{}
+	c.startAudit(slow)
+	for i := 0; i < auditEventsBufferSize+2; i++ {
+		c.auditProduce(p, &nats.Msg{})
+	}
+	if dropped := c.DroppedAuditEvents(); dropped == 0 {
+		t.Error("expected events to be dropped while the sink is blocked")
+	}
+	close(slow.block)
+	c.closeAudit()
+}
+
+func TestAuditSinkDlsMsgs(t *testing.T) {
+	sink := &testAuditSink{}
+	c := &Conn{}
+	c.startAudit(sink)
+	consumer := &Consumer{Name: "consumer_a", ConsumerGroup: "group_a", stationName: "station_a", conn: c, dlsCh: make(chan *nats.Msg, 1)}
+	consumer.dlsCh <- &nats.Msg{Header: nats.Header{"msg-id": []string{"id-1"}}, Data: []byte("hey")}
+
+	msgs := consumer.drainDlsMsgs()
+	c.closeAudit()
+	if len(msgs) != 1 || msgs[0].Station() != "station_a" {
+		t.Fatalf("expected the dead letter message to be wrapped, got %v", msgs)
+	}
+	if len(sink.consumes) != 1 || sink.consumes[0].MsgId != "id-1" {
+		t.Errorf("expected the dead letter message to be audited, got %+v", sink.consumes)
+	}
+}
+
+func TestMaxConcurrentSchemaOps(t *testing.T) {
+	opts := getDefaultOptions()
+	if err := WithMaxConcurrentSchemaOps(0)(&opts); err == nil {
+		t.Error("expected error for a non positive limit")
+	}
+	(&Conn{}).acquireSchemaOp()()
+
+	c := &Conn{schemaOps: make(chan struct{}, 1)}
+	release := c.acquireSchemaOp()
+	acquired := make(chan struct{})
+	go func() {
+		c.acquireSchemaOp()()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the second operation to wait for a slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("expected the second operation to get the released slot")
+	}
+}
+
+func TestWithInboxPrefix(t *testing.T) {
+	opts := getDefaultOptions()
+	for _, prefix := range []string{"", "_INBOX.", ".tenant", "tenant..inbox", "tenant.*", "tenant.>", "ten ant"} {
+		if err := WithInboxPrefix(prefix)(&opts); err == nil {
+			t.Errorf("expected error for inbox prefix %q", prefix)
+		}
+	}
+	if err := WithInboxPrefix("_INBOX_tenant.a")(&opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.InboxPrefix != "_INBOX_tenant.a" {
+		t.Errorf("unexpected inbox prefix %q", opts.InboxPrefix)
+	}
+}
+
+func TestWithTLSServerName(t *testing.T) {
+	opts := getDefaultOptions()
+	if err := WithTLSServerName("")(&opts); err == nil {
+		t.Error("expected error for an empty server name")
+	}
+	for _, opt := range []Option{WithTLSServerName("broker.internal"), Tls("cert.pem", "key.pem", "ca.pem")} {
+		if err := opt(&opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opts.TLSServerName != "broker.internal" {
+		t.Errorf("server name was reset by Tls, got %q", opts.TLSServerName)
+	}
+
+	opts.TLSOpts = TLSOpts{}
+	c := &Conn{opts: opts}
+	if err := c.startConn(); err == nil || !strings.Contains(err.Error(), "TLS isn't configured") {
+		t.Errorf("expected error for a server name without TLS, got %v", err)
+	}
+}
@@ -16,13 +16,20 @@ package memphis
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 const (
@@ -30,6 +37,7 @@ const (
 	dlsSubjPrefix                  = "$memphis_dls"
 	memphisPmAckSubject            = "$memphis_pm_acks"
 	lastConsumerCreationReqVersion = 1
+	typedConsumerErrsBufferSize    = 64
 )
 
 var (
@@ -49,6 +57,7 @@ type Consumer struct {
 	conn                     *Conn
 	stationName              string
 	subscription             *nats.Subscription
+	subscriptionMu           sync.RWMutex
 	pingInterval             time.Duration
 	subscriptionActive       bool
 	firstFetch               bool
@@ -60,13 +69,26 @@ type Consumer struct {
 	StartConsumeFromSequence uint64
 	LastMessages             int64
 	context                  context.Context
+	nakOnCancel              bool
+	expiredMsgHandler        ExpiredMsgHandler
+	concurrency              int
+	maxDeliveries            int
+	poisonMsgHandler         PoisonMsgHandler
+	autoHeartbeat            time.Duration
+	filter                   MsgFilter
+	interceptors             []ConsumeInterceptor
 }
 
 // Msg - a received message, can be acked.
 type Msg struct {
-	msg    *nats.Msg
-	conn   *Conn
-	cgName string
+	msg      *nats.Msg
+	conn     *Conn
+	cgName   string
+	station  string
+	metaOnce sync.Once
+	meta     *nats.MsgMetadata
+	metaErr  error
+	handled  uint32
 }
 
 type PMsgToAck struct {
@@ -79,17 +101,133 @@ func (m *Msg) Data() []byte {
 	return m.msg.Data
 }
 
+// Msg.metadata - the message's JetStream metadata, parsed once on first access.
+func (m *Msg) metadata() (*nats.MsgMetadata, error) {
+	m.metaOnce.Do(func() {
+		m.meta, m.metaErr = m.msg.Metadata()
+	})
+	return m.meta, m.metaErr
+}
+
+// Msg.Timestamp - get the time the message was stored by the broker, zero time if unknown.
+func (m *Msg) Timestamp() time.Time {
+	meta, err := m.metadata()
+	if err != nil {
+		return time.Time{}
+	}
+	return meta.Timestamp
+}
+
+// Msg.StreamSequence - get the message's sequence number in the station, 0 if unknown.
+func (m *Msg) StreamSequence() uint64 {
+	meta, err := m.metadata()
+	if err != nil {
+		return 0
+	}
+	return meta.Sequence.Stream
+}
+
+// Msg.ConsumerSequence - get the message's delivery sequence number of the consumer group, 0 if unknown.
+func (m *Msg) ConsumerSequence() uint64 {
+	meta, err := m.metadata()
+	if err != nil {
+		return 0
+	}
+	return meta.Sequence.Consumer
+}
+
+// Msg.DeliveryCount - get the number of times the message was delivered, 1 on first delivery, 0 if unknown.
+func (m *Msg) DeliveryCount() int {
+	meta, err := m.metadata()
+	if err != nil {
+		return 0
+	}
+	return int(meta.NumDelivered)
+}
+
+// Msg.Station - get the name of the station the message was consumed from.
+func (m *Msg) Station() string {
+	return m.station
+}
+
 // Msg.GetSequenceNumber - get message's sequence number
 func (m *Msg) GetSequenceNumber() (uint64, error) {
-	meta, err := m.msg.Metadata()
+	meta, err := m.metadata()
 	if err != nil {
 		return 0, nil
 	}
 	return meta.Sequence.Stream, nil
 }
 
+// Msg.EventTime - get the event time set on produce with WithEventTime, false if the message has none.
+func (m *Msg) EventTime() (time.Time, bool) {
+	eventTime := m.msg.Header.Get(eventTimeHeader)
+	if eventTime == "" {
+		return time.Time{}, false
+	}
+	eventTimeMillis, err := strconv.ParseInt(eventTime, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(eventTimeMillis), true
+}
+
+// Msg.SchemaVersion - the number of the station's schema version the message was validated against on produce,
+// 0 for messages produced to stations without a schema, without validation or by clients that don't stamp it.
+func (m *Msg) SchemaVersion() int {
+	version, err := strconv.Atoi(m.msg.Header.Get(schemaVersionHeader))
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+// Msg.BinaryHeader - get a binary header attached on produce with WithBinaryHeader.
+func (m *Msg) BinaryHeader(key string) ([]byte, bool) {
+	values, ok := m.msg.Header[binaryHeaderPrefix+key]
+	if !ok || len(values) == 0 {
+		return nil, false
+	}
+	value, err := base64.StdEncoding.DecodeString(values[0])
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Msg.isExpired - whether the message's TTL, set on produce, has elapsed since it was stored.
+func (m *Msg) isExpired(now time.Time) bool {
+	ttl := m.msg.Header.Get(ttlHeader)
+	if ttl == "" {
+		return false
+	}
+	ttlMillis, err := strconv.ParseInt(ttl, 10, 64)
+	if err != nil {
+		return false
+	}
+	meta, err := m.metadata()
+	if err != nil {
+		return false
+	}
+	return now.After(meta.Timestamp.Add(time.Duration(ttlMillis) * time.Millisecond))
+}
+
+// Msg.deliveryDelay - time left until the message may be delivered, as set on produce with WithDeliverAfter.
+func (m *Msg) deliveryDelay(now time.Time) time.Duration {
+	deliverAt := m.msg.Header.Get(deliverAtHeader)
+	if deliverAt == "" {
+		return 0
+	}
+	deliverAtMillis, err := strconv.ParseInt(deliverAt, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.UnixMilli(deliverAtMillis).Sub(now)
+}
+
 // Msg.Ack - ack the message.
 func (m *Msg) Ack() error {
+	m.markHandled()
 	err := m.msg.Ack()
 	if err != nil {
 		headers := m.GetHeaders()
@@ -105,7 +243,7 @@ func (m *Msg) Ack() error {
 					ID:       id,
 					Sequence: seq,
 				}
-				msgToPublish, _ := json.Marshal(msgToAck)
+				msgToPublish, _ := m.conn.marshalJSON(msgToAck)
 				m.conn.brokerConn.Publish(memphisPmAckSubject, msgToPublish)
 			}
 		}
@@ -113,6 +251,56 @@ func (m *Msg) Ack() error {
 	return nil
 }
 
+// Msg.Respond - replies to a message produced with Producer.Request, the reply is passed as is without schema validation.
+func (m *Msg) Respond(data []byte) error {
+	replyTo := m.msg.Header.Get(replyToHeader)
+	if replyTo == "" {
+		return memphisError(errors.New("message wasn't produced with Producer.Request"))
+	}
+
+	reply := nats.NewMsg(replyTo)
+	reply.Data = data
+	reply.Header.Set(correlationIdHeader, m.msg.Header.Get(correlationIdHeader))
+	reply.Header.Set("$memphis_connectionId", m.conn.ConnId)
+	return memphisError(m.conn.brokerConn.PublishMsg(reply))
+}
+
+// Msg.InProgress - tell the broker the message is still being handled, resetting its ack wait timer
+// so it isn't redelivered while a slow handler is running.
+func (m *Msg) InProgress() error {
+	return memphisError(m.msg.InProgress())
+}
+
+// Msg.nak - nacks the message for redelivery.
+func (m *Msg) nak() error {
+	m.markHandled()
+	return m.msg.Nak()
+}
+
+func (m *Msg) markHandled() {
+	atomic.StoreUint32(&m.handled, 1)
+}
+
+func (m *Msg) isHandled() bool {
+	return atomic.LoadUint32(&m.handled) == 1
+}
+
+// AckMany - ack a batch of messages, memphis consumers ack explicitly so each message is acked
+// and the errors of all failed acks are returned.
+func AckMany(msgs []*Msg) error {
+	var errs []error
+	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		if err := msg.Ack(); err != nil {
+			seq, _ := msg.GetSequenceNumber()
+			errs = append(errs, fmt.Errorf("ack of message %v failed: %w", seq, err))
+		}
+	}
+	return memphisError(joinErrors(errs...))
+}
+
 // Msg.GetHeaders - get headers per message
 func (m *Msg) GetHeaders() map[string]string {
 	headers := map[string]string{}
@@ -122,9 +310,50 @@ func (m *Msg) GetHeaders() map[string]string {
 	return headers
 }
 
+// Msg.Decode - decode the message's data into v, msgpack is used for messages whose content-type header is
+// application/msgpack and json otherwise.
+func (m *Msg) Decode(v any) error {
+	if ct, _ := m.GetHeaderIgnoreCase(contentTypeKey); ct == msgpackContentType {
+		return memphisError(msgpack.Unmarshal(m.msg.Data, v))
+	}
+	return memphisError(m.conn.unmarshalJSON(m.msg.Data, v))
+}
+
+// Msg.GetHeaderIgnoreCase - get the first value of a message header, matching the key case-insensitively.
+// Header keys keep their original casing on the wire, when several keys match the exact match is preferred.
+func (m *Msg) GetHeaderIgnoreCase(key string) (string, bool) {
+	if values, ok := m.msg.Header[key]; ok && len(values) > 0 {
+		return values[0], true
+	}
+	for k, values := range m.msg.Header {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return values[0], true
+		}
+	}
+	return "", false
+}
+
 // ConsumerErrHandler is used to process asynchronous errors.
 type ConsumerErrHandler func(*Consumer, error)
 
+// ExpiredMsgHandler is called with messages whose TTL elapsed before they were consumed, those messages are acked.
+type ExpiredMsgHandler func(*Consumer, *Msg)
+
+// HandlerFunc handles a single message.
+type HandlerFunc func(*Msg)
+
+// ConsumeInterceptor wraps a HandlerFunc, e.g. for tracing, metrics or panic recovery.
+type ConsumeInterceptor func(next HandlerFunc) HandlerFunc
+
+// PanicHandler is called with a message whose handler panicked and the recovered value.
+type PanicHandler func(msg *Msg, recovered any)
+
+// MsgFilter reports whether a received message should be handled, messages it rejects are acked and skipped.
+type MsgFilter func(*Msg) bool
+
+// PoisonMsgHandler is called with messages delivered more times than the consumer's max deliveries, those messages are acked.
+type PoisonMsgHandler func(*Msg)
+
 type createConsumerReq struct {
 	Name                     string `json:"name"`
 	StationName              string `json:"station_name"`
@@ -160,9 +389,18 @@ type ConsumerOpts struct {
 	MaxAckTime               time.Duration
 	MaxMsgDeliveries         int
 	GenUniqueSuffix          bool
+	UniqueSuffixNumBytes     int
 	ErrHandler               ConsumerErrHandler
 	StartConsumeFromSequence uint64
 	LastMessages             int64
+	NakOnCancel              bool
+	ExpiredMsgHandler        ExpiredMsgHandler
+	Concurrency              int
+	MaxDeliveries            int
+	PoisonMsgHandler         PoisonMsgHandler
+	AutoHeartbeat            time.Duration
+	Filter                   MsgFilter
+	Interceptors             []ConsumeInterceptor
 }
 
 // getDefaultConsumerOptions - returns default configuration options for consumers.
@@ -174,9 +412,11 @@ func getDefaultConsumerOptions() ConsumerOpts {
 		MaxAckTime:               30 * time.Second,
 		MaxMsgDeliveries:         10,
 		GenUniqueSuffix:          false,
+		UniqueSuffixNumBytes:     defaultUniqueSuffixNumBytes,
 		ErrHandler:               DefaultConsumerErrHandler,
 		StartConsumeFromSequence: 1,
 		LastMessages:             -1,
+		Concurrency:              1,
 	}
 }
 
@@ -207,7 +447,7 @@ func (opts *ConsumerOpts) createConsumer(c *Conn) (*Consumer, error) {
 	var err error
 
 	if opts.GenUniqueSuffix {
-		opts.Name, err = extendNameWithRandSuffix(opts.Name)
+		opts.Name, err = extendNameWithRandSuffix(opts.Name, opts.UniqueSuffixNumBytes)
 		if err != nil {
 			return nil, memphisError(err)
 		}
@@ -225,6 +465,14 @@ func (opts *ConsumerOpts) createConsumer(c *Conn) (*Consumer, error) {
 		errHandler:               opts.ErrHandler,
 		StartConsumeFromSequence: opts.StartConsumeFromSequence,
 		LastMessages:             opts.LastMessages,
+		nakOnCancel:              opts.NakOnCancel,
+		expiredMsgHandler:        opts.ExpiredMsgHandler,
+		concurrency:              opts.Concurrency,
+		maxDeliveries:            opts.MaxDeliveries,
+		poisonMsgHandler:         opts.PoisonMsgHandler,
+		autoHeartbeat:            opts.AutoHeartbeat,
+		filter:                   opts.Filter,
+		interceptors:             opts.Interceptors,
 	}
 
 	if consumer.StartConsumeFromSequence == 0 {
@@ -255,12 +503,13 @@ func (opts *ConsumerOpts) createConsumer(c *Conn) (*Consumer, error) {
 	subj := subjInternalName + ".final"
 
 	durable := getInternalName(consumer.ConsumerGroup)
-	consumer.subscription, err = c.brokerPullSubscribe(subj,
-		durable,
+	subOpts := []nats.SubOpt{
 		nats.ManualAck(),
 		nats.MaxRequestExpires(consumer.BatchMaxTimeToWait),
 		nats.MaxRequestBatch(opts.BatchSize),
-		nats.MaxDeliver(opts.MaxMsgDeliveries))
+		nats.MaxDeliver(opts.MaxMsgDeliveries),
+	}
+	consumer.subscription, err = c.brokerPullSubscribe(subj, durable, subOpts...)
 
 	if err != nil {
 		return nil, memphisError(err)
@@ -269,6 +518,7 @@ func (opts *ConsumerOpts) createConsumer(c *Conn) (*Consumer, error) {
 	consumer.subscriptionActive = true
 
 	go consumer.pingConsumer()
+	c.trackConsumer(&consumer)
 
 	return &consumer, err
 }
@@ -288,9 +538,32 @@ func (c *Consumer) callErrHandler(err error) {
 	}
 }
 
+func (c *Consumer) isSubscriptionActive() bool {
+	c.subscriptionMu.RLock()
+	defer c.subscriptionMu.RUnlock()
+	return c.subscriptionActive
+}
+
+// Consumer.unsubscribe - stops the consumer's subscription and pings, the consumer group created by the broker is kept.
+func (c *Consumer) unsubscribe() error {
+	c.subscriptionMu.Lock()
+	active := c.subscriptionActive
+	c.subscriptionActive = false
+	c.subscriptionMu.Unlock()
+	if !active {
+		return nil
+	}
+	select {
+	case c.pingQuit <- struct{}{}:
+	default:
+		// the pinger was already asked to quit
+	}
+	return c.subscription.Unsubscribe()
+}
+
 func (c *Consumer) pingConsumer() {
 	ticker := time.NewTicker(c.pingInterval)
-	if !c.subscriptionActive {
+	if !c.isSubscriptionActive() {
 		log.Fatal("started ping for inactive subscription")
 	}
 
@@ -298,8 +571,14 @@ func (c *Consumer) pingConsumer() {
 		select {
 		case <-ticker.C:
 			_, err := c.subscription.ConsumerInfo()
+			if err != nil && c.conn.isReconnecting(err) {
+				// the subscription is re-established once the connection is
+				continue
+			}
 			if err != nil {
+				c.subscriptionMu.Lock()
 				c.subscriptionActive = false
+				c.subscriptionMu.Unlock()
 				c.callErrHandler(ConsumerErrStationUnreachable)
 				c.StopConsume()
 				return
@@ -311,6 +590,24 @@ func (c *Consumer) pingConsumer() {
 	}
 }
 
+// Consumer.Lag - get the number of messages of the station the consumer group still has to process,
+// both undelivered messages and messages delivered but not acked yet.
+func (c *Consumer) Lag() (uint64, error) {
+	if !c.isSubscriptionActive() {
+		return 0, memphisError(errors.New("station unreachable"))
+	}
+
+	info, err := c.subscription.ConsumerInfo()
+	if err != nil {
+		return 0, memphisError(err)
+	}
+	return consumerLag(info), nil
+}
+
+func consumerLag(info *nats.ConsumerInfo) uint64 {
+	return info.NumPending + uint64(info.NumAckPending)
+}
+
 // Consumer.SetContext - set a context that will be passed to each message handler function call
 func (c *Consumer) SetContext(ctx context.Context) {
 	c.context = ctx
@@ -320,7 +617,7 @@ func (c *Consumer) SetContext(ctx context.Context) {
 type ConsumeHandler func([]*Msg, error, context.Context)
 
 // Consumer.Consume - start consuming messages according to the interval configured in the consumer object.
-// When a batch is consumed the handlerFunc will be called.
+// When a batch is consumed the handlerFunc will be called, wrapped by the consume interceptors of the batch's messages.
 func (c *Consumer) Consume(handlerFunc ConsumeHandler) error {
 	go func(c *Consumer) {
 		if c.firstFetch {
@@ -332,7 +629,11 @@ func (c *Consumer) Consume(handlerFunc ConsumeHandler) error {
 
 			c.firstFetch = false
 			msgs, err := c.fetchSubscription()
-			handlerFunc(msgs, memphisError(err), c.context)
+			c.withAutoHeartbeat(msgs, func() {
+				c.interceptBatch(msgs, func(msgs []*Msg) {
+					handlerFunc(msgs, memphisError(err), c.context)
+				})
+			})
 		}
 
 		ticker := time.NewTicker(c.PullInterval)
@@ -356,12 +657,22 @@ func (c *Consumer) Consume(handlerFunc ConsumeHandler) error {
 				}
 
 				// push messages from the dls channel to the user's handler
-				for len(c.dlsCh) > 0 {
-					dlsMsg := Msg{msg: <-c.dlsCh, conn: c.conn, cgName: c.ConsumerGroup}
-					msgs = append(msgs, &dlsMsg)
+				msgs = append(msgs, c.drainDlsMsgs()...)
+
+				if err != nil || c.concurrency <= 1 {
+					c.withAutoHeartbeat(msgs, func() {
+						c.interceptBatch(msgs, func(msgs []*Msg) {
+							handlerFunc(msgs, memphisError(err), nil)
+						})
+					})
+					continue
 				}
-
-				handlerFunc(msgs, memphisError(err), nil)
+				handler := c.handlerChain(func(msg *Msg) {
+					handlerFunc([]*Msg{msg}, nil, nil)
+				})
+				c.withAutoHeartbeat(msgs, func() {
+					handleConcurrently(msgs, c.concurrency, handler)
+				})
 			case <-c.consumeQuit:
 				return
 			}
@@ -371,6 +682,384 @@ func (c *Consumer) Consume(handlerFunc ConsumeHandler) error {
 	return nil
 }
 
+// Consumer.ConsumeWithContext - consume messages one by one until ctx is done, then unsubscribes and returns ctx.Err().
+// A handler call in progress when ctx is done is allowed to finish, the rest of the fetched batch is
+// handled as well unless the consumer was created with NakOnCancel, in which case it is nacked for redelivery.
+// The consumer group is kept, but the consumer can't consume anymore once ConsumeWithContext returned.
+func (c *Consumer) ConsumeWithContext(ctx context.Context, handler func(*Msg)) error {
+	handler = c.handlerChain(handler)
+	if c.firstFetch {
+		if err := c.firstFetchInit(); err != nil {
+			return memphisError(err)
+		}
+		c.firstFetch = false
+	}
+	defer func() {
+		if err := c.unsubscribe(); err != nil {
+			c.callErrHandler(err)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		msgs, err := c.fetchSubscriptionWithContext(ctx)
+		if err != nil && ctx.Err() == nil && !errors.Is(err, nats.ErrTimeout) && !errors.Is(err, context.DeadlineExceeded) {
+			c.callErrHandler(err)
+		}
+		msgs = append(msgs, c.drainDlsMsgs()...)
+
+		c.withAutoHeartbeat(msgs, func() {
+			handleConcurrently(msgs, c.concurrency, func(msg *Msg) {
+				if ctx.Err() != nil && c.nakOnCancel {
+					msg.nak()
+					return
+				}
+				handler(msg)
+			})
+		})
+		if ctx.Err() != nil && c.nakOnCancel {
+			return ctx.Err()
+		}
+
+		if len(msgs) == 0 {
+			timer := time.NewTimer(c.PullInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// BatchHandler - handler of a batch of consumed messages, the batch is nacked when it returns an error.
+type BatchHandler func([]*Msg) error
+
+// Consumer.ConsumeBatch - start consuming messages in the background in batches of up to batchSize messages, a batch is
+// handled once it's full or maxWait after its first message was fetched. Once handler returns the batch is acked,
+// or nacked for redelivery when it returns an error, which is passed to the consumer's error handler. Acking is
+// all or nothing per batch except for messages the handler acked itself, which are neither acked nor nacked again.
+// Fetch failures are passed to the error handler and retried after PullInterval.
+// Messages accumulated when StopConsume is called are nacked. The handler is wrapped by the consume interceptors of the batch's messages.
+func (c *Consumer) ConsumeBatch(batchSize int, maxWait time.Duration, handler BatchHandler) error {
+	if batchSize <= 0 {
+		return memphisError(errors.New("batch size has to be a positive number"))
+	}
+	if maxWait <= 0 {
+		return memphisError(errors.New("max wait has to be a positive duration"))
+	}
+	if handler == nil {
+		return memphisError(errors.New("batch handler can't be nil"))
+	}
+	if c.firstFetch {
+		if err := c.firstFetchInit(); err != nil {
+			return memphisError(err)
+		}
+		c.firstFetch = false
+	}
+
+	go func() {
+		var batch []*Msg
+		var flushAt time.Time
+		for {
+			select {
+			case <-c.consumeQuit:
+				nakBatch(batch)
+				return
+			default:
+			}
+
+			wait := maxWait
+			if len(batch) > 0 {
+				wait = time.Until(flushAt)
+			}
+			if wait > 0 {
+				msgs, err := c.fetch(batchSize-len(batch), nats.MaxWait(wait))
+				if err != nil && !errors.Is(err, nats.ErrTimeout) {
+					c.callErrHandler(err)
+					// back off so a fetch failing right away doesn't spin
+					timer := time.NewTimer(c.PullInterval)
+					select {
+					case <-c.consumeQuit:
+						timer.Stop()
+						nakBatch(batch)
+						return
+					case <-timer.C:
+					}
+				}
+				msgs = append(msgs, c.drainDlsMsgs()...)
+				if len(batch) == 0 && len(msgs) > 0 {
+					flushAt = time.Now().Add(maxWait)
+				}
+				batch = append(batch, msgs...)
+			}
+
+			if len(batch) > 0 && (len(batch) >= batchSize || !time.Now().Before(flushAt)) {
+				c.handleBatch(batch, handler)
+				batch = nil
+			}
+		}
+	}()
+	c.consumeActive = true
+	return nil
+}
+
+// handleBatch - calls handler with the batch, then acks the batch or nacks it if handler failed.
+// The batch is nacked as well if the handler didn't return, i.e. an interceptor recovered from its panic.
+func (c *Consumer) handleBatch(batch []*Msg, handler BatchHandler) {
+	err := errors.New("batch handler didn't return")
+	c.withAutoHeartbeat(batch, func() {
+		c.interceptBatch(batch, func(msgs []*Msg) {
+			if len(msgs) == 0 {
+				err = nil
+				return
+			}
+			err = handler(msgs)
+		})
+	})
+	if err != nil {
+		nakBatch(batch)
+		c.callErrHandler(memphisError(fmt.Errorf("batch of %v messages nacked: %w", len(batch), err)))
+		return
+	}
+
+	unhandled := make([]*Msg, 0, len(batch))
+	for _, msg := range batch {
+		if !msg.isHandled() {
+			unhandled = append(unhandled, msg)
+		}
+	}
+	if err := AckMany(unhandled); err != nil {
+		c.callErrHandler(err)
+	}
+}
+
+// nakBatch - nacks the messages of the batch the handler didn't ack or nack itself.
+func nakBatch(batch []*Msg) {
+	for _, msg := range batch {
+		if !msg.isHandled() {
+			msg.nak()
+		}
+	}
+}
+
+// handleConcurrently - calls handle for each message on up to concurrency worker goroutines and returns once all
+// messages are handled, with a concurrency of 1 messages are handled in order on the calling goroutine.
+func handleConcurrently(msgs []*Msg, concurrency int, handle func(*Msg)) {
+	if concurrency <= 1 {
+		for _, msg := range msgs {
+			handle(msg)
+		}
+		return
+	}
+
+	msgsCh := make(chan *Msg)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(msgs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range msgsCh {
+				handle(msg)
+			}
+		}()
+	}
+	for _, msg := range msgs {
+		msgsCh <- msg
+	}
+	close(msgsCh)
+	wg.Wait()
+}
+
+// AckPolicy - when a TypedConsumer acks the messages it decodes.
+type AckPolicy int
+
+const (
+	// AckAfterDelivery - ack a message once its decoded value is received from the channel, messages whose value
+	// wasn't received before the context is done are redelivered.
+	AckAfterDelivery AckPolicy = iota
+	// AckOnReceipt - ack a message as soon as it is decoded, values not received before the context is done are lost.
+	AckOnReceipt
+)
+
+// TypedConsumer - consumes messages decoded into values of type T.
+type TypedConsumer[T any] struct {
+	consumer    *Consumer
+	ackPolicy   AckPolicy
+	droppedErrs uint64
+}
+
+// NewTypedConsumer - wraps consumer to consume messages decoded into values of type T with Msg.Decode.
+func NewTypedConsumer[T any](consumer *Consumer, ackPolicy AckPolicy) *TypedConsumer[T] {
+	return &TypedConsumer[T]{consumer: consumer, ackPolicy: ackPolicy}
+}
+
+// TypedConsumer.Channel - consume until ctx is done, streaming decoded values and errors (decode and ack failures)
+// on the returned channels. Both channels are closed once ctx is done and the message in progress is handled,
+// so values can be ranged over. Messages that fail to decode aren't acked and are redelivered until MaxMsgDeliveries.
+// Errors are buffered and never block values, errors that don't fit in the buffer because errs isn't read are dropped
+// and counted by TypedConsumer.DroppedErrors.
+func (tc *TypedConsumer[T]) Channel(ctx context.Context) (<-chan T, <-chan error) {
+	values := make(chan T)
+	errs := make(chan error, typedConsumerErrsBufferSize)
+	sendErr := func(err error) {
+		select {
+		case errs <- err:
+		default:
+			atomic.AddUint64(&tc.droppedErrs, 1)
+		}
+	}
+
+	go func() {
+		defer close(errs)
+		defer close(values)
+		err := tc.consumer.ConsumeWithContext(ctx, func(msg *Msg) {
+			tc.deliver(ctx, msg, values, sendErr)
+		})
+		if err != nil && ctx.Err() == nil {
+			sendErr(err)
+		}
+	}()
+
+	return values, errs
+}
+
+// TypedConsumer.DroppedErrors - get the number of errors dropped because the errors channel wasn't read.
+func (tc *TypedConsumer[T]) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&tc.droppedErrs)
+}
+
+// TypedConsumer.deliver - decodes msg, sends its value on values and acks it according to the ack policy.
+func (tc *TypedConsumer[T]) deliver(ctx context.Context, msg *Msg, values chan<- T, sendErr func(error)) {
+	var v T
+	if err := msg.Decode(&v); err != nil {
+		sendErr(err)
+		return
+	}
+	if tc.ackPolicy == AckOnReceipt {
+		if err := msg.Ack(); err != nil {
+			sendErr(err)
+		}
+	}
+
+	select {
+	case values <- v:
+	case <-ctx.Done():
+		return
+	}
+	if tc.ackPolicy == AckAfterDelivery {
+		if err := msg.Ack(); err != nil {
+			sendErr(err)
+		}
+	}
+}
+
+// interceptBatch - calls handle with the messages of msgs the consumer's interceptors passed on, nested in the
+// interceptors of every message so they wrap handling the batch like they wrap handling a single message.
+// Messages an interceptor doesn't pass on are left out of the batch handle is called with.
+func (c *Consumer) interceptBatch(msgs []*Msg, handle func([]*Msg)) {
+	if len(c.interceptors) == 0 {
+		handle(msgs)
+		return
+	}
+	passed := make([]*Msg, 0, len(msgs))
+	var next func(i int)
+	next = func(i int) {
+		if i == len(msgs) {
+			handle(passed)
+			return
+		}
+		called := false
+		c.handlerChain(func(msg *Msg) {
+			called = true
+			passed = append(passed, msg)
+			next(i + 1)
+		})(msgs[i])
+		if !called {
+			next(i + 1)
+		}
+	}
+	next(0)
+}
+
+// handlerChain - the consumer's interceptors wrapping handler, the first interceptor is the outermost.
+func (c *Consumer) handlerChain(handler HandlerFunc) HandlerFunc {
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		handler = c.interceptors[i](handler)
+	}
+	return handler
+}
+
+// RecoverPanics - a consume interceptor recovering from panics of the handlers it wraps, the message is nacked
+// for redelivery and passed to onPanic with the recovered value, the panic is logged if onPanic is nil.
+func RecoverPanics(onPanic PanicHandler) ConsumeInterceptor {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(msg *Msg) {
+			defer func() {
+				if r := recover(); r != nil {
+					msg.nak()
+					if onPanic != nil {
+						onPanic(msg, r)
+						return
+					}
+					log.Printf("message handler panicked: %v", r)
+				}
+			}()
+			next(msg)
+		}
+	}
+}
+
+// Consumer.withAutoHeartbeat - calls handle, and while it runs signals the broker every autoHeartbeat interval
+// that the messages not yet acked are in progress.
+func (c *Consumer) withAutoHeartbeat(msgs []*Msg, handle func()) {
+	heartbeat(msgs, c.autoHeartbeat, func(msg *Msg) {
+		if err := msg.InProgress(); err != nil {
+			c.callErrHandler(err)
+		}
+	}, handle)
+}
+
+// heartbeat - calls handle and calls beat for each unhandled message every interval until handle returns,
+// handle is called directly if interval isn't positive.
+func heartbeat(msgs []*Msg, interval time.Duration, beat func(*Msg), handle func()) {
+	if interval <= 0 || len(msgs) == 0 {
+		handle()
+		return
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, msg := range msgs {
+					if !msg.isHandled() {
+						beat(msg)
+					}
+				}
+			}
+		}
+	}()
+
+	defer wg.Wait()
+	defer close(done)
+	handle()
+}
+
 // StopConsume - stops the continuous consume operation.
 func (c *Consumer) StopConsume() {
 	if !c.consumeActive {
@@ -382,23 +1071,67 @@ func (c *Consumer) StopConsume() {
 }
 
 func (c *Consumer) fetchSubscription() ([]*Msg, error) {
-	if !c.subscriptionActive {
+	return c.fetch(c.BatchSize)
+}
+
+// fetchSubscriptionWithContext - fetch a batch, waiting up to BatchMaxTimeToWait or until ctx is done.
+func (c *Consumer) fetchSubscriptionWithContext(ctx context.Context) ([]*Msg, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, c.BatchMaxTimeToWait)
+	defer cancel()
+	return c.fetch(c.BatchSize, nats.Context(fetchCtx))
+}
+
+// fetch - fetch up to n messages from the consumer's subscription.
+func (c *Consumer) fetch(n int, opts ...nats.PullOpt) ([]*Msg, error) {
+	if !c.isSubscriptionActive() {
 		return nil, memphisError(errors.New("station unreachable"))
 	}
 
-	subscription := c.subscription
-	batchSize := c.BatchSize
-	msgs, err := subscription.Fetch(batchSize)
+	msgs, err := c.subscription.Fetch(n, opts...)
 	if err != nil {
 		return nil, memphisError(err)
 	}
 
-	wrappedMsgs := make([]*Msg, 0, batchSize)
+	return c.wrapFetchedMsgs(msgs), nil
+}
 
+// isPoison - whether the message was delivered more times than the consumer's max deliveries.
+func (c *Consumer) isPoison(msg *Msg) bool {
+	return c.maxDeliveries > 0 && msg.DeliveryCount() > c.maxDeliveries
+}
+
+// wrapFetchedMsgs - wraps fetched messages, poison and expired messages are acked and passed to their handlers instead,
+// messages that shouldn't be delivered yet are nacked until their delivery time and messages rejected by the filter are acked.
+func (c *Consumer) wrapFetchedMsgs(msgs []*nats.Msg) []*Msg {
+	wrappedMsgs := make([]*Msg, 0, len(msgs))
 	for _, msg := range msgs {
-		wrappedMsgs = append(wrappedMsgs, &Msg{msg: msg, conn: c.conn, cgName: c.ConsumerGroup})
+		wrappedMsg := &Msg{msg: msg, conn: c.conn, cgName: c.ConsumerGroup, station: c.stationName}
+		c.conn.auditConsume(c, wrappedMsg)
+		if c.isPoison(wrappedMsg) {
+			wrappedMsg.Ack()
+			if c.poisonMsgHandler != nil {
+				c.poisonMsgHandler(wrappedMsg)
+			}
+			continue
+		}
+		if wrappedMsg.isExpired(time.Now()) {
+			wrappedMsg.Ack()
+			if c.expiredMsgHandler != nil {
+				c.expiredMsgHandler(c, wrappedMsg)
+			}
+			continue
+		}
+		if delay := wrappedMsg.deliveryDelay(time.Now()); delay > 0 {
+			msg.NakWithDelay(delay)
+			continue
+		}
+		if c.filter != nil && !c.filter(wrappedMsg) {
+			wrappedMsg.Ack()
+			continue
+		}
+		wrappedMsgs = append(wrappedMsgs, wrappedMsg)
 	}
-	return wrappedMsgs, nil
+	return wrappedMsgs
 }
 
 type fetchResult struct {
@@ -448,6 +1181,15 @@ func (c *Consumer) createDlsMsgHandler() nats.MsgHandler {
 	}
 }
 
+// drainDlsMsgs - wraps the dead letter messages waiting to be passed to the handler the same way fetched messages are.
+func (c *Consumer) drainDlsMsgs() []*Msg {
+	var msgs []*nats.Msg
+	for len(c.dlsCh) > 0 {
+		msgs = append(msgs, <-c.dlsCh)
+	}
+	return c.wrapFetchedMsgs(msgs)
+}
+
 func (c *Consumer) getDlsSubjName() string {
 	stationName := getInternalName(c.stationName)
 	consumerGroup := getInternalName(c.ConsumerGroup)
@@ -463,11 +1205,106 @@ func (c *Consumer) Destroy() error {
 	if c.consumeActive {
 		c.StopConsume()
 	}
-	if c.subscriptionActive {
+	if c.isSubscriptionActive() {
 		c.pingQuit <- struct{}{}
 	}
 
-	return c.conn.destroy(c)
+	if err := c.conn.destroy(c); err != nil {
+		return err
+	}
+	c.conn.untrackConsumer(c)
+	return nil
+}
+
+// WildcardConsumer - consumes the stations matching a pattern, with a consumer per station.
+type WildcardConsumer struct {
+	consumers []*Consumer
+}
+
+// Conn.CreateWildcardConsumer - creates a consumer for each existing station matching pattern, in which '*' matches
+// any sequence of characters. Stations created after the consumer are not consumed.
+func (c *Conn) CreateWildcardConsumer(pattern, name string, opts ...ConsumerOpt) (*WildcardConsumer, error) {
+	names, err := c.brokerStreamNames()
+	if err != nil {
+		return nil, memphisError(fmt.Errorf("failed listing stations: %w", err))
+	}
+	stations, err := matchStations(names, pattern)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	if len(stations) == 0 {
+		return nil, memphisError(fmt.Errorf("no station matches %q", pattern))
+	}
+
+	wc := WildcardConsumer{}
+	for _, station := range stations {
+		consumer, err := c.CreateConsumer(station, name, opts...)
+		if err != nil {
+			return nil, memphisError(joinErrors(err, wc.Destroy()))
+		}
+		wc.consumers = append(wc.consumers, consumer)
+	}
+	return &wc, nil
+}
+
+// matchStations - returns the names of the stations whose internal names match pattern, memphis internal streams are skipped.
+func matchStations(internalNames []string, pattern string) ([]string, error) {
+	internalPattern := getInternalName(pattern)
+	var stations []string
+	for _, internalName := range internalNames {
+		if strings.HasPrefix(internalName, "$memphis") {
+			continue
+		}
+		matched, err := path.Match(internalPattern, internalName)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			stations = append(stations, strings.Replace(internalName, delimReplacement, delimToReplace, -1))
+		}
+	}
+	sort.Strings(stations)
+	return stations, nil
+}
+
+// WildcardConsumer.Stations - get the names of the consumed stations.
+func (wc *WildcardConsumer) Stations() []string {
+	stations := make([]string, 0, len(wc.consumers))
+	for _, consumer := range wc.consumers {
+		stations = append(stations, consumer.stationName)
+	}
+	return stations
+}
+
+// WildcardConsumer.Consume - start consuming all matching stations, the handler is called concurrently
+// for batches of different stations and ordering across stations is best effort. Use Msg.Station to get a message's station.
+// If a station fails to start, the stations already started are stopped.
+func (wc *WildcardConsumer) Consume(handlerFunc ConsumeHandler) error {
+	for i, consumer := range wc.consumers {
+		if err := consumer.Consume(handlerFunc); err != nil {
+			for _, started := range wc.consumers[:i] {
+				started.StopConsume()
+			}
+			return memphisError(err)
+		}
+	}
+	return nil
+}
+
+// WildcardConsumer.StopConsume - stops consuming all matching stations.
+func (wc *WildcardConsumer) StopConsume() {
+	for _, consumer := range wc.consumers {
+		consumer.StopConsume()
+	}
+}
+
+// WildcardConsumer.Destroy - destroys the consumers of all matching stations.
+func (wc *WildcardConsumer) Destroy() error {
+	var errs []error
+	for _, consumer := range wc.consumers {
+		errs = append(errs, consumer.Destroy())
+	}
+	return memphisError(joinErrors(errs...))
 }
 
 func (c *Consumer) getCreationSubject() string {
@@ -577,6 +1414,18 @@ func ConsumerGenUniqueSuffix() ConsumerOpt {
 	}
 }
 
+// ConsumerGenUniqueSuffixN - whether to generate a unique suffix of numBytes random bytes for this consumer, default length is 4 bytes.
+func ConsumerGenUniqueSuffixN(numBytes int) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if numBytes <= 0 {
+			return errors.New("unique suffix length has to be a positive number")
+		}
+		opts.GenUniqueSuffix = true
+		opts.UniqueSuffixNumBytes = numBytes
+		return nil
+	}
+}
+
 // ConsumerErrorHandler - handler for consumer errors.
 func ConsumerErrorHandler(ceh ConsumerErrHandler) ConsumerOpt {
 	return func(opts *ConsumerOpts) error {
@@ -585,6 +1434,89 @@ func ConsumerErrorHandler(ceh ConsumerErrHandler) ConsumerOpt {
 	}
 }
 
+// WithMaxDeliveries - messages delivered more than n times are acked and passed to onPoison instead of the consume handler,
+// preventing endless redelivery when the station has no dead letter configuration.
+// The broker stops redelivering messages after MaxMsgDeliveries deliveries, so n has to be lower than it to take effect.
+// Dead letter messages carry no delivery count, so they're never considered poison.
+func WithMaxDeliveries(n int, onPoison PoisonMsgHandler) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if n <= 0 {
+			return errors.New("max deliveries has to be a positive number")
+		}
+		opts.MaxDeliveries = n
+		opts.PoisonMsgHandler = onPoison
+		return nil
+	}
+}
+
+// WithConsumeInterceptor - wrap the handlers of the consumer with interceptor, interceptors run in the order they
+// are added, the first one added is the outermost and the last one calls the handler. The handlers of Consume and
+// ConsumeBatch are called once per batch, nested in the interceptors of each of the batch's messages, messages an
+// interceptor doesn't pass on are left out of the batch.
+func WithConsumeInterceptor(interceptor ConsumeInterceptor) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if interceptor == nil {
+			return errors.New("interceptor can't be nil")
+		}
+		opts.Interceptors = append(opts.Interceptors, interceptor)
+		return nil
+	}
+}
+
+// WithFilter - skip received messages the filter rejects, e.g. by a header identifying the message type.
+// Skipped messages are acked, not nacked, so no other consumer of the consumer group receives them.
+// Filtering is done by the client after the messages are fetched, not by the broker.
+func WithFilter(filter MsgFilter) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		opts.Filter = filter
+		return nil
+	}
+}
+
+// ExpiredMsgsHandler - handler for messages whose TTL elapsed before they were consumed.
+func ExpiredMsgsHandler(emh ExpiredMsgHandler) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		opts.ExpiredMsgHandler = emh
+		return nil
+	}
+}
+
+// WithConcurrency - handle the messages of each fetched batch on n worker goroutines, default is 1.
+// Message ordering is not kept when n > 1, the next batch is fetched only once all messages of the current one are handled,
+// so no more than BatchSize messages are in progress at once and StopConsume waits for in progress messages.
+// With Consume, the handler is called with a single message at a time.
+func WithConcurrency(n int) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if n <= 0 {
+			return errors.New("concurrency has to be a positive number")
+		}
+		opts.Concurrency = n
+		return nil
+	}
+}
+
+// WithAutoHeartbeat - while the handler is running, signal the broker every interval that the handled messages
+// are in progress so they aren't redelivered once MaxAckTime passes, signals for a message stop once it is acked.
+// interval has to be shorter than MaxAckTime.
+func WithAutoHeartbeat(interval time.Duration) ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		if interval <= 0 {
+			return errors.New("auto heartbeat interval has to be positive")
+		}
+		opts.AutoHeartbeat = interval
+		return nil
+	}
+}
+
+// NakOnCancel - when the context of ConsumeWithContext is done, nack the unhandled messages of the fetched batch
+// for redelivery instead of handling them.
+func NakOnCancel() ConsumerOpt {
+	return func(opts *ConsumerOpts) error {
+		opts.NakOnCancel = true
+		return nil
+	}
+}
+
 func StartConsumeFromSequence(startConsumeFromSequence uint64) ConsumerOpt {
 	return func(opts *ConsumerOpts) error {
 		opts.StartConsumeFromSequence = startConsumeFromSequence
@@ -0,0 +1,745 @@
+package memphis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// newTestPullConsumer - a consumer of a pull subscription to the test broker's consumer group "durable" of stream "stream".
+func newTestPullConsumer(t *testing.T, c *Conn) *Consumer {
+	sub, err := c.js.PullSubscribe("station.final", "durable", nats.Bind("stream", "durable"), nats.ManualAck())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Consumer{
+		conn:               c,
+		subscription:       sub,
+		subscriptionActive: true,
+		BatchSize:          2,
+		BatchMaxTimeToWait: time.Second,
+		PullInterval:       time.Millisecond,
+		pingQuit:           make(chan struct{}, 1),
+		consumeQuit:        make(chan struct{}),
+		dlsCh:              make(chan *nats.Msg, 1),
+	}
+}
+
+// testConsumerInfo - the broker's reply to a consumer info request of newTestPullConsumer's subscription.
+func testConsumerInfo(pub testPublish) []*nats.Msg {
+	if strings.HasPrefix(pub.subject, "$JS.API.CONSUMER.INFO.") {
+		return testReply(`{"stream_name":"stream","name":"durable","config":{"durable_name":"durable","ack_policy":"explicit"}}`)
+	}
+	return nil
+}
+
+func TestConsumeWithContextCancel(t *testing.T) {
+	acks := make(chan string, 10)
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		switch {
+		case strings.HasPrefix(pub.subject, "$JS.API.CONSUMER.MSG.NEXT."):
+			return []*nats.Msg{
+				{Reply: "$JS.ACK.stream.durable.1.1.1.0.0", Data: []byte("a")},
+				{Reply: "$JS.ACK.stream.durable.1.2.2.0.0", Data: []byte("b")},
+			}
+		case strings.HasPrefix(pub.subject, "$JS.ACK."):
+			acks <- pub.subject + " " + string(pub.data)
+		}
+		return testConsumerInfo(pub)
+	})
+	consumer := newTestPullConsumer(t, c)
+	consumer.nakOnCancel = true
+	sub := consumer.subscription
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var handled []string
+	err := consumer.ConsumeWithContext(ctx, func(msg *Msg) {
+		handled = append(handled, string(msg.Data()))
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if strings.Join(handled, ",") != "a" {
+		t.Errorf("the loop should stop once the context is cancelled, handled %v", handled)
+	}
+	select {
+	case ack := <-acks:
+		if ack != "$JS.ACK.stream.durable.1.2.2.0.0 -NAK" {
+			t.Errorf("expected the unhandled message to be nacked, got %v", ack)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the unhandled message wasn't nacked")
+	}
+	if sub.IsValid() || consumer.isSubscriptionActive() {
+		t.Error("the consumer should be unsubscribed once the context is cancelled")
+	}
+	if len(consumer.pingQuit) != 1 {
+		t.Error("the consumer's pings should be stopped")
+	}
+}
+
+func TestAckMany(t *testing.T) {
+	acks := make(chan string, 10)
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		switch {
+		case strings.HasPrefix(pub.subject, "$JS.API.CONSUMER.MSG.NEXT."):
+			return []*nats.Msg{
+				{Reply: "$JS.ACK.stream.durable.1.1.1.0.0", Data: []byte("a")},
+				{Reply: "$JS.ACK.stream.durable.1.2.2.0.0", Data: []byte("b")},
+			}
+		case strings.HasPrefix(pub.subject, "$JS.ACK."):
+			acks <- pub.subject
+		}
+		return testConsumerInfo(pub)
+	})
+	msgs, err := newTestPullConsumer(t, c).fetch(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbound := &Msg{msg: &nats.Msg{Subject: "station.final"}}
+
+	err = AckMany([]*Msg{msgs[0], nil, unbound, msgs[1]})
+	if err == nil || !strings.Contains(err.Error(), "ack of message") {
+		t.Errorf("expected the failed ack's error, got %v", err)
+	}
+	for _, want := range []string{"$JS.ACK.stream.durable.1.1.1.0.0", "$JS.ACK.stream.durable.1.2.2.0.0"} {
+		select {
+		case ack := <-acks:
+			if ack != want {
+				t.Errorf("expected an ack of %v, got %v", want, ack)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("a failed ack should not stop the rest of the batch from being acked")
+		}
+	}
+}
+
+func TestMsgIsExpired(t *testing.T) {
+	storedAt := time.Now().Add(-time.Minute)
+
+	msg := Msg{msg: newTestJsMsg(storedAt, nats.Header{ttlHeader: []string{"1000"}})}
+	if !msg.isExpired(time.Now()) {
+		t.Error("message should be expired")
+	}
+
+	msg = Msg{msg: newTestJsMsg(storedAt, nats.Header{ttlHeader: []string{"120000"}})}
+	if msg.isExpired(time.Now()) {
+		t.Error("message should not be expired")
+	}
+
+	msg = Msg{msg: newTestJsMsg(storedAt, nats.Header{})}
+	if msg.isExpired(time.Now()) {
+		t.Error("message without ttl should not expire")
+	}
+}
+
+func TestMsgDeliveryDelay(t *testing.T) {
+	now := time.Now()
+	deliverAt := strconv.FormatInt(now.Add(time.Minute).UnixMilli(), 10)
+
+	msg := Msg{msg: newTestJsMsg(now, nats.Header{deliverAtHeader: []string{deliverAt}})}
+	if delay := msg.deliveryDelay(now); delay <= 59*time.Second {
+		t.Errorf("unexpected delay: %v", delay)
+	}
+	if delay := msg.deliveryDelay(now.Add(2 * time.Minute)); delay > 0 {
+		t.Errorf("message should be deliverable, delay: %v", delay)
+	}
+
+	msg = Msg{msg: newTestJsMsg(now, nats.Header{})}
+	if msg.deliveryDelay(now) != 0 {
+		t.Error("message without delivery time should be deliverable")
+	}
+}
+
+func TestConsumerLag(t *testing.T) {
+	info := &nats.ConsumerInfo{NumPending: 7, NumAckPending: 3}
+	if lag := consumerLag(info); lag != 10 {
+		t.Errorf("expected lag 10, got %v", lag)
+	}
+}
+
+func TestMsgGetHeaderIgnoreCase(t *testing.T) {
+	msg := Msg{msg: &nats.Msg{Header: nats.Header{"Content-Type": []string{"json"}, "x-id": []string{"1"}, "X-ID": []string{"2"}}}}
+
+	if v, ok := msg.GetHeaderIgnoreCase("content-type"); !ok || v != "json" {
+		t.Errorf("expected json, got %q", v)
+	}
+	if v, ok := msg.GetHeaderIgnoreCase("X-ID"); !ok || v != "2" {
+		t.Errorf("expected the exact match 2, got %q", v)
+	}
+	if _, ok := msg.GetHeaderIgnoreCase("missing"); ok {
+		t.Error("expected missing header not to be found")
+	}
+	if key := CanonicalHeaderKey("content-type"); key != "Content-Type" {
+		t.Errorf("expected Content-Type, got %v", key)
+	}
+}
+
+func TestMsgpackEncoding(t *testing.T) {
+	opts := ProducerOpts{}
+	if err := WithMsgpackEncoding()(&opts); err != nil {
+		t.Fatal(err)
+	}
+	p := Producer{Name: "producer_name_a", conn: &Conn{}, encoder: opts.DefaultEncoder, contentType: opts.ContentType}
+
+	type event struct {
+		Id   int
+		Name string
+	}
+	data, err := p.rawMsgBytes(event{Id: 1, Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := p.rawMsgBytes([]byte("raw")); err != nil || string(raw) != "raw" {
+		t.Error("raw bytes should pass through")
+	}
+
+	headers := p.buildMsgHeaders(map[string][]string{contentTypeKey: {p.defaultContentType(event{}, "")}}, nil)
+	if len(headers[contentTypeKey]) != 1 {
+		t.Fatalf("expected a single content-type header, got %v", headers)
+	}
+	msg := Msg{msg: &nats.Msg{Header: headers, Data: data}}
+	var decoded event
+	if err := msg.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Id != 1 || decoded.Name != "a" {
+		t.Errorf("unexpected decoded message %+v", decoded)
+	}
+
+	msg = Msg{msg: &nats.Msg{Data: []byte(`{"Id":2}`)}}
+	if err := msg.Decode(&decoded); err != nil || decoded.Id != 2 {
+		t.Errorf("expected json decoding, got %+v, %v", decoded, err)
+	}
+
+	msg = Msg{msg: &nats.Msg{Header: nats.Header{"Content-Type": {msgpackContentType}}, Data: data}}
+	decoded = event{}
+	if err := msg.Decode(&decoded); err != nil || decoded.Id != 1 {
+		t.Errorf("an explicit content type should select the decoding, got %+v, %v", decoded, err)
+	}
+}
+
+func TestHandleConcurrently(t *testing.T) {
+	msgs := make([]*Msg, 20)
+	for i := range msgs {
+		msgs[i] = &Msg{msg: &nats.Msg{Data: []byte{byte(i)}}}
+	}
+
+	var order []byte
+	handleConcurrently(msgs, 1, func(msg *Msg) {
+		order = append(order, msg.Data()[0])
+	})
+	for i, b := range order {
+		if int(b) != i {
+			t.Fatal("messages should be handled in order with concurrency 1")
+		}
+	}
+
+	var mu sync.Mutex
+	var inProgress, maxInProgress, handled int
+	handleConcurrently(msgs, 4, func(msg *Msg) {
+		mu.Lock()
+		inProgress++
+		if inProgress > maxInProgress {
+			maxInProgress = inProgress
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inProgress--
+		handled++
+		mu.Unlock()
+	})
+	if handled != len(msgs) {
+		t.Errorf("expected %v handled messages, got %v", len(msgs), handled)
+	}
+	if maxInProgress > 4 || maxInProgress < 2 {
+		t.Errorf("expected up to 4 concurrent handlers, got %v", maxInProgress)
+	}
+}
+
+func TestMsgDeliveryCount(t *testing.T) {
+	jsMsg := &nats.Msg{Sub: &nats.Subscription{}, Reply: "$JS.ACK.station.consumer.4.5.5.0.0"}
+	msg := &Msg{msg: jsMsg}
+	if count := msg.DeliveryCount(); count != 4 {
+		t.Errorf("expected delivery count 4, got %v", count)
+	}
+	if count := (&Msg{msg: &nats.Msg{}}).DeliveryCount(); count != 0 {
+		t.Errorf("expected delivery count 0 without metadata, got %v", count)
+	}
+
+	c := Consumer{maxDeliveries: 3}
+	if !c.isPoison(msg) {
+		t.Error("message delivered more than max deliveries should be poison")
+	}
+	c.maxDeliveries = 4
+	if c.isPoison(msg) {
+		t.Error("message delivered max deliveries times should not be poison")
+	}
+	if (&Consumer{}).isPoison(msg) {
+		t.Error("messages should not be poison without max deliveries")
+	}
+}
+
+func TestMatchStations(t *testing.T) {
+	names := []string{"orders#eu", "orders#us", "payments", "$memphis_dls"}
+
+	stations, err := matchStations(names, "Orders.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 2 || stations[0] != "orders.eu" || stations[1] != "orders.us" {
+		t.Errorf("unexpected stations %v", stations)
+	}
+
+	stations, _ = matchStations(names, "*")
+	if len(stations) != 3 {
+		t.Errorf("memphis internal streams should be skipped, got %v", stations)
+	}
+
+	if _, err := matchStations(names, "["); err == nil {
+		t.Error("expected error for a malformed pattern")
+	}
+}
+
+func TestCreateWildcardConsumerListingError(t *testing.T) {
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		if pub.subject == "$JS.API.STREAM.NAMES" {
+			return testReply(`{"error":{"code":503,"description":"jetstream not enabled"}}`)
+		}
+		return nil
+	})
+
+	_, err := c.CreateWildcardConsumer("orders.*", "consumer_a")
+	if err == nil || !strings.Contains(err.Error(), "jetstream not enabled") {
+		t.Errorf("expected the listing error, got %v", err)
+	}
+}
+
+func TestBrokerStreamNamesPages(t *testing.T) {
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		if strings.Contains(string(pub.data), `"offset":2`) {
+			return testReply(`{"total":3,"streams":["payments"]}`)
+		}
+		return testReply(`{"total":3,"streams":["orders#eu","orders#us"]}`)
+	})
+
+	names, err := c.brokerStreamNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "orders#eu,orders#us,payments" {
+		t.Errorf("expected the streams of all pages, got %v", names)
+	}
+}
+
+func TestBrokerStreamNamesConnSettings(t *testing.T) {
+	var mu sync.Mutex
+	var subjects []string
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		mu.Lock()
+		defer mu.Unlock()
+		subjects = append(subjects, pub.subject)
+		return testReply(`{"total":1,"streams":["orders"]}`)
+	})
+	c.jsAPIPrefix = "$JS.hub.API."
+	var marshaled, unmarshaled bool
+	c.opts.JSONMarshaler = func(v any) ([]byte, error) {
+		marshaled = true
+		return json.Marshal(v)
+	}
+	c.opts.JSONUnmarshaler = func(data []byte, v any) error {
+		unmarshaled = true
+		return json.Unmarshal(data, v)
+	}
+
+	if _, err := c.brokerStreamNames(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(subjects) != 1 || subjects[0] != "$JS.hub.API.STREAM.NAMES" {
+		t.Errorf("expected the request under the connection's API prefix, got %v", subjects)
+	}
+	if !marshaled || !unmarshaled {
+		t.Error("expected the connection's JSON marshaler and unmarshaler to be used")
+	}
+}
+
+func TestMsgMetadataAccessors(t *testing.T) {
+	storedAt := time.Now().Add(-time.Minute)
+	msg := &Msg{msg: &nats.Msg{Sub: &nats.Subscription{}, Reply: fmt.Sprintf("$JS.ACK.station.consumer.1.7.3.%v.0", storedAt.UnixNano())}}
+
+	if !msg.Timestamp().Equal(storedAt) {
+		t.Errorf("expected timestamp %v, got %v", storedAt, msg.Timestamp())
+	}
+	if seq := msg.StreamSequence(); seq != 7 {
+		t.Errorf("expected stream sequence 7, got %v", seq)
+	}
+	if seq := msg.ConsumerSequence(); seq != 3 {
+		t.Errorf("expected consumer sequence 3, got %v", seq)
+	}
+
+	msg = &Msg{msg: &nats.Msg{}}
+	if !msg.Timestamp().IsZero() || msg.StreamSequence() != 0 || msg.ConsumerSequence() != 0 {
+		t.Error("expected zero values without metadata")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	msgs := []*Msg{{msg: &nats.Msg{}}, {msg: &nats.Msg{}}}
+	beats := make(chan *Msg)
+	stopped := make(chan struct{})
+	var returned int32
+	beat := func(msg *Msg) {
+		if atomic.LoadInt32(&returned) == 1 {
+			t.Error("heartbeats should stop once the handler returns")
+		}
+		select {
+		case beats <- msg:
+		case <-stopped:
+		}
+	}
+	nextBeat := func() *Msg {
+		select {
+		case msg := <-beats:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected heartbeats while the handler is running")
+			return nil
+		}
+	}
+
+	heartbeat(msgs, time.Millisecond, beat, func() {
+		defer close(stopped)
+		if nextBeat() != msgs[0] || nextBeat() != msgs[1] {
+			t.Fatal("expected a heartbeat per unhandled message")
+		}
+		msgs[0].markHandled()
+		// the tick in progress may have checked msgs[0] before it was handled, the ones after it may not
+		for seen := 0; seen < 2; {
+			if msg := nextBeat(); msg == msgs[1] {
+				seen++
+			} else if seen > 0 {
+				t.Fatal("heartbeats should stop once a message is handled")
+			}
+		}
+	})
+	atomic.StoreInt32(&returned, 1)
+
+	called := false
+	heartbeat(msgs, 0, beat, func() { called = true })
+	if !called {
+		t.Error("handler should be called without heartbeats")
+	}
+}
+
+func TestConsumerFilter(t *testing.T) {
+	order := &nats.Msg{Header: nats.Header{"type": []string{"order"}}}
+	refund := &nats.Msg{Header: nats.Header{"type": []string{"refund"}}}
+	c := Consumer{filter: func(msg *Msg) bool {
+		return msg.GetHeaders()["type"] == "order"
+	}}
+
+	msgs := c.wrapFetchedMsgs([]*nats.Msg{order, refund})
+	if len(msgs) != 1 || msgs[0].msg != order {
+		t.Errorf("expected only the matching message, got %v messages", len(msgs))
+	}
+
+	c.dlsCh = make(chan *nats.Msg, 2)
+	c.dlsCh <- order
+	c.dlsCh <- refund
+	if msgs := c.drainDlsMsgs(); len(msgs) != 1 || msgs[0].msg != order {
+		t.Errorf("expected the filter to apply to dead letter messages, got %v messages", len(msgs))
+	}
+
+	c.filter = nil
+	if msgs := c.wrapFetchedMsgs([]*nats.Msg{order, refund}); len(msgs) != 2 {
+		t.Errorf("expected all messages without a filter, got %v", len(msgs))
+	}
+}
+
+func TestTypedConsumerDeliver(t *testing.T) {
+	type order struct {
+		ID int `json:"id"`
+	}
+	tc := NewTypedConsumer[order](&Consumer{}, AckAfterDelivery)
+	values := make(chan order, 1)
+	var errs []error
+	sendErr := func(err error) { errs = append(errs, err) }
+
+	// the test message isn't bound to a subscription, so acking it fails
+	msg := &Msg{msg: &nats.Msg{Data: []byte(`{"id": 7}`)}}
+	tc.deliver(context.Background(), msg, values, sendErr)
+	if v := <-values; v.ID != 7 {
+		t.Errorf("unexpected value %+v", v)
+	}
+	if len(errs) != 1 || !msg.isHandled() {
+		t.Errorf("expected the message to be acked after delivery, got errors %v", errs)
+	}
+
+	errs = nil
+	tc.deliver(context.Background(), &Msg{msg: &nats.Msg{Data: []byte("{")}}, values, sendErr)
+	if len(errs) != 1 || len(values) != 0 {
+		t.Error("expected a decode error and no value")
+	}
+
+	errs = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	values <- order{}
+	msg = &Msg{msg: &nats.Msg{Data: []byte(`{"id": 8}`)}}
+	tc.deliver(ctx, msg, values, sendErr)
+	if msg.isHandled() || len(errs) != 0 {
+		t.Error("a value not delivered before the context is done shouldn't be acked")
+	}
+}
+
+func TestTypedConsumerUnreadErrors(t *testing.T) {
+	var fetches int32
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		if strings.HasPrefix(pub.subject, "$JS.API.CONSUMER.MSG.NEXT.") {
+			if atomic.AddInt32(&fetches, 1) == 1 {
+				return []*nats.Msg{
+					{Reply: "$JS.ACK.stream.durable.1.1.1.0.0", Data: []byte("{")},
+					{Reply: "$JS.ACK.stream.durable.1.2.2.0.0", Data: []byte("{")},
+				}
+			}
+			return []*nats.Msg{{Reply: "$JS.ACK.stream.durable.1.3.3.0.0", Data: []byte("7")}}
+		}
+		return testConsumerInfo(pub)
+	})
+	tc := NewTypedConsumer[int](newTestPullConsumer(t, c), AckOnReceipt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	values, errs := tc.Channel(ctx)
+	select {
+	case v := <-values:
+		if v != 7 {
+			t.Errorf("unexpected value %v", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("unread decode errors shouldn't stop values from being delivered")
+	}
+	cancel()
+	for range values {
+	}
+	if n := len(errs); n != 2 {
+		t.Errorf("expected the 2 decode errors to be buffered, got %v", n)
+	}
+}
+
+func TestConsumeInterceptors(t *testing.T) {
+	var calls []string
+	record := func(name string) ConsumeInterceptor {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(msg *Msg) {
+				calls = append(calls, name)
+				next(msg)
+			}
+		}
+	}
+	var recovered any
+	opts := getDefaultConsumerOptions()
+	for _, opt := range []ConsumerOpt{WithConsumeInterceptor(record("first")), WithConsumeInterceptor(RecoverPanics(func(msg *Msg, r any) { recovered = r })), WithConsumeInterceptor(record("second"))} {
+		if err := opt(&opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WithConsumeInterceptor(nil)(&opts); err == nil {
+		t.Error("expected error for a nil interceptor")
+	}
+
+	c := &Consumer{interceptors: opts.Interceptors}
+	handler := c.handlerChain(func(msg *Msg) {
+		panic("handler failed")
+	})
+	msg := &Msg{msg: &nats.Msg{}}
+	handler(msg)
+	if strings.Join(calls, ",") != "first,second" {
+		t.Errorf("interceptors should run in the order they were added, got %v", calls)
+	}
+	if recovered != "handler failed" {
+		t.Errorf("expected the panic to be recovered, got %v", recovered)
+	}
+	if !msg.isHandled() {
+		t.Error("a message whose handler panicked should be nacked")
+	}
+
+	var batchErr error
+	c.errHandler = func(_ *Consumer, err error) { batchErr = err }
+	batch := []*Msg{{msg: &nats.Msg{}}, {msg: &nats.Msg{}}}
+	c.handleBatch(batch, func([]*Msg) error {
+		panic("batch handler failed")
+	})
+	if recovered != "batch handler failed" || batchErr == nil {
+		t.Errorf("expected the batch handler's panic to be recovered and the batch nacked, got %v", batchErr)
+	}
+	for _, msg := range batch {
+		if !msg.isHandled() {
+			t.Error("every message of a batch whose handler panicked should be nacked")
+		}
+	}
+}
+
+func TestConsumeWithInterceptors(t *testing.T) {
+	var fetches int32
+	conn := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		if strings.HasPrefix(pub.subject, "$JS.API.CONSUMER.MSG.NEXT.") && atomic.AddInt32(&fetches, 1) == 1 {
+			return []*nats.Msg{
+				{Reply: "$JS.ACK.stream.durable.1.1.1.0.0", Data: []byte("a")},
+				{Reply: "$JS.ACK.stream.durable.1.2.2.0.0", Data: []byte("skip")},
+				{Reply: "$JS.ACK.stream.durable.1.3.3.0.0", Data: []byte("b")},
+			}
+		}
+		return testConsumerInfo(pub)
+	})
+	c := newTestPullConsumer(t, conn)
+	c.BatchSize = 3
+	var mu sync.Mutex
+	var intercepted []string
+	c.interceptors = []ConsumeInterceptor{func(next HandlerFunc) HandlerFunc {
+		return func(msg *Msg) {
+			mu.Lock()
+			intercepted = append(intercepted, string(msg.Data()))
+			mu.Unlock()
+			if string(msg.Data()) != "skip" {
+				next(msg)
+			}
+		}
+	}}
+
+	batches := make(chan []string, 10)
+	if err := c.Consume(func(msgs []*Msg, err error, ctx context.Context) {
+		var data []string
+		for _, msg := range msgs {
+			data = append(data, string(msg.Data()))
+		}
+		if len(data) > 0 {
+			batches <- data
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.StopConsume()
+
+	select {
+	case batch := <-batches:
+		if strings.Join(batch, ",") != "a,b" {
+			t.Errorf("expected the messages the interceptor passed on, got %v", batch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a batch to be handled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(intercepted, ",") != "a,skip,b" {
+		t.Errorf("expected every message to be intercepted, got %v", intercepted)
+	}
+}
+
+func TestConsumeBatchArgs(t *testing.T) {
+	c := &Consumer{}
+	handler := func([]*Msg) error { return nil }
+	if err := c.ConsumeBatch(0, time.Second, handler); err == nil {
+		t.Error("expected error for a non positive batch size")
+	}
+	if err := c.ConsumeBatch(10, 0, handler); err == nil {
+		t.Error("expected error for a non positive max wait")
+	}
+	if err := c.ConsumeBatch(10, time.Second, nil); err == nil {
+		t.Error("expected error for a nil handler")
+	}
+}
+
+func TestConsumeBatchFetchFailureBackoff(t *testing.T) {
+	errs := make(chan error, 100)
+	c := &Consumer{
+		PullInterval: time.Hour,
+		consumeQuit:  make(chan struct{}),
+		dlsCh:        make(chan *nats.Msg, 1),
+		errHandler:   func(_ *Consumer, err error) { errs <- err },
+	}
+	if err := c.ConsumeBatch(10, time.Second, func([]*Msg) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscription is inactive so the fetch fails right away, the next one waits for PullInterval
+	<-errs
+	select {
+	case err := <-errs:
+		t.Fatalf("fetch retried without backing off: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// stopping isn't blocked by the back off
+	c.StopConsume()
+}
+
+func TestConsumeBatch(t *testing.T) {
+	c, err := Connect("localhost", "root", "memphis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	p, err := c.CreateProducer("station_name_batch", "producer_name_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := c.CreateConsumer("station_name_batch", "consumer_name_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := p.Produce([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	batches := make(chan int, 10)
+	failed := false
+	err = consumer.ConsumeBatch(3, time.Second, func(msgs []*Msg) error {
+		batches <- len(msgs)
+		if !failed {
+			failed = true
+			return errors.New("insert failed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.StopConsume()
+
+	select {
+	case n := <-batches:
+		if n != 3 {
+			t.Errorf("expected a full batch of 3, got %v", n)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no batch handled")
+	}
+
+	// the first batch failed and is redelivered, so all 5 messages are handled after it
+	handled := 0
+	for handled < 5 {
+		select {
+		case n := <-batches:
+			handled += n
+		case <-time.After(10 * time.Second):
+			t.Fatalf("handled %v messages", handled)
+		}
+	}
+}
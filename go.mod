@@ -8,13 +8,17 @@ require (
 	google.golang.org/protobuf v1.28.1
 )
 
-require github.com/santhosh-tekuri/jsonschema/v5 v5.1.0
+require (
+	github.com/santhosh-tekuri/jsonschema/v5 v5.1.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+)
 
 require (
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/nats-io/nats-server/v2 v2.9.5 // indirect
 	github.com/nats-io/nkeys v0.3.0 // indirect
 	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	golang.org/x/crypto v0.0.0-20220926161630-eccd6366d1be // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
@@ -15,14 +15,24 @@
 package memphis
 
 import (
+	"context"
+	"encoding"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 const (
@@ -30,16 +40,62 @@ const (
 	memphisNotificationsSubject    = "$memphis_notifications"
 	schemaVFailAlertType           = "schema_validation_fail_alert"
 	lastProducerCreationReqVersion = 1
+	defaultUniqueSuffixNumBytes    = 4
+	defaultAckWaitSec              = 15
+	ttlHeader                      = "$memphis_ttl_ms"
+	deliverAtHeader                = "$memphis_deliver_at_ms"
+	eventTimeHeader                = "$memphis_event_time_ms"
+	contentTypeKey                 = "content-type"
+	schemaVersionHeader            = "$memphis_schema_version"
+	replyToHeader                  = "$memphis_reply_to"
+	correlationIdHeader            = "$memphis_correlation_id"
+	binaryHeaderPrefix             = "$memphis_bin_"
+	msgpackContentType             = "application/msgpack"
+)
+
+var (
+	ErrHeadersTooLarge    = errors.New("headers too large")
+	ErrTooManyHeaders     = errors.New("too many headers")
+	ErrMessageTooLarge    = errors.New("message too large")
+	ErrRateLimited        = errors.New("produce rate limit exceeded")
+	ErrProduceTimeout     = errors.New("produce timed out")
+	ErrUnsupportedMsgType = errors.New("Unsupported message type")
+	ErrCircuitOpen        = errors.New("produce circuit breaker is open")
+	ErrRequestTimeout     = errors.New("request timed out waiting for a reply")
+	ErrSchemaNotLoaded    = errors.New("the station's schema isn't loaded")
+	ErrSchemaValidation   = errors.New("Schema validation has failed")
+	ErrBrokerTimeout      = errors.New("timed out waiting for the broker")
+	ErrNoResponders       = errors.New("no responders available for the station")
 )
 
 // Producer - memphis producer object.
 type Producer struct {
-	Name        string
-	stationName string
-	conn        *Conn
-	realName    string
+	Name               string
+	stationName        string
+	conn               *Conn
+	realName           string
+	defaultHeaders     map[string][]string
+	defaultAckWaitSec  int
+	encoder            Encoder
+	validationDisabled bool
+	eagerSchema        bool
+	lazySchema         bool
+	schemaMu           sync.Mutex
+	schemaListening    bool
+	pendingSchemaInit  SchemaUpdateInit
+	rateLimiter        *rateLimiter
+	rateLimitFailFast  bool
+	contentType        string
+	jsonEncoder        bool
+	circuitBreaker     *circuitBreaker
+	inflight           chan struct{}
+	interceptors       []ProduceInterceptor
+	config             ProducerConfig
 }
 
+// Encoder - encodes messages produced to stations without a schema.
+type Encoder func(any) ([]byte, error)
+
 type createProducerReq struct {
 	Name           string `json:"name"`
 	StationName    string `json:"station_name"`
@@ -63,6 +119,16 @@ const (
 	SchemaUpdateTypeDrop
 )
 
+// SchemaType - the type of a station's schema, as named by the broker.
+type SchemaType string
+
+const (
+	SchemaTypeProtobuf SchemaType = "protobuf"
+	SchemaTypeJSON     SchemaType = "json"
+	SchemaTypeAvro     SchemaType = "avro"
+	SchemaTypeGraphQL  SchemaType = "graphql"
+)
+
 type SchemaUpdate struct {
 	UpdateType SchemaUpdateType
 	Init       SchemaUpdateInit `json:"init,omitempty"`
@@ -71,7 +137,7 @@ type SchemaUpdate struct {
 type SchemaUpdateInit struct {
 	SchemaName    string        `json:"schema_name"`
 	ActiveVersion SchemaVersion `json:"active_version"`
-	SchemaType    string        `json:"type"`
+	SchemaType    SchemaType    `json:"type"`
 }
 
 type SchemaVersion struct {
@@ -89,7 +155,144 @@ type removeProducerReq struct {
 
 // ProducerOpts - configuration options for producer creation.
 type ProducerOpts struct {
-	GenUniqueSuffix bool
+	GenUniqueSuffix        bool
+	UniqueSuffixNumBytes   int
+	HostAndPidSuffix       bool
+	DefaultHeaders         Headers
+	DefaultAckWaitSec      int
+	DefaultEncoder         Encoder
+	ValidationDisabled     bool
+	EagerSchema            bool
+	LazySchema             bool
+	RateLimitPerSecond     int
+	RateLimitBurst         int
+	RateLimitFailFast      bool
+	ContentType            string
+	jsonEncoder            bool
+	CircuitBreakerFailures int
+	CircuitBreakerCooldown time.Duration
+	MaxInflight            int
+	Interceptors           []ProduceInterceptor
+}
+
+// ProduceFunc - produces a message with the given options.
+type ProduceFunc func(message any, opts *ProduceOpts) error
+
+// ProduceInterceptor - wraps a ProduceFunc, e.g. to stamp headers, time produces or transform messages.
+type ProduceInterceptor func(next ProduceFunc) ProduceFunc
+
+// ProducerConfig - a producer's configuration, can be serialized to JSON and used to create similar producers
+// with Conn.CreateProducerFromConfig. Custom encoders set with WithDefaultEncoder and interceptors aren't part of the configuration.
+type ProducerConfig struct {
+	Name                   string              `json:"name"`
+	StationName            string              `json:"station_name"`
+	GenUniqueSuffix        bool                `json:"gen_unique_suffix,omitempty"`
+	UniqueSuffixNumBytes   int                 `json:"unique_suffix_num_bytes,omitempty"`
+	HostAndPidSuffix       bool                `json:"host_and_pid_suffix,omitempty"`
+	DefaultHeaders         map[string][]string `json:"default_headers,omitempty"`
+	DefaultAckWaitSec      int                 `json:"default_ack_wait_sec,omitempty"`
+	JSONEncoder            bool                `json:"json_encoder,omitempty"`
+	ValidationDisabled     bool                `json:"validation_disabled,omitempty"`
+	EagerSchema            bool                `json:"eager_schema,omitempty"`
+	LazySchema             bool                `json:"lazy_schema,omitempty"`
+	RateLimitPerSecond     int                 `json:"rate_limit_per_second,omitempty"`
+	RateLimitBurst         int                 `json:"rate_limit_burst,omitempty"`
+	RateLimitFailFast      bool                `json:"rate_limit_fail_fast,omitempty"`
+	ContentType            string              `json:"content_type,omitempty"`
+	CircuitBreakerFailures int                 `json:"circuit_breaker_failures,omitempty"`
+	CircuitBreakerCooldown time.Duration       `json:"circuit_breaker_cooldown,omitempty"`
+	MaxInflight            int                 `json:"max_inflight,omitempty"`
+}
+
+// ProducerOpts.config - the configuration of a producer created with these options.
+func (opts *ProducerOpts) config(stationName, name string) ProducerConfig {
+	return ProducerConfig{
+		Name:                   name,
+		StationName:            stationName,
+		GenUniqueSuffix:        opts.GenUniqueSuffix,
+		UniqueSuffixNumBytes:   opts.UniqueSuffixNumBytes,
+		HostAndPidSuffix:       opts.HostAndPidSuffix,
+		DefaultHeaders:         copyHeaders(opts.DefaultHeaders.MsgHeaders),
+		DefaultAckWaitSec:      opts.DefaultAckWaitSec,
+		JSONEncoder:            opts.jsonEncoder,
+		ValidationDisabled:     opts.ValidationDisabled,
+		EagerSchema:            opts.EagerSchema,
+		LazySchema:             opts.LazySchema,
+		RateLimitPerSecond:     opts.RateLimitPerSecond,
+		RateLimitBurst:         opts.RateLimitBurst,
+		RateLimitFailFast:      opts.RateLimitFailFast,
+		ContentType:            opts.ContentType,
+		CircuitBreakerFailures: opts.CircuitBreakerFailures,
+		CircuitBreakerCooldown: opts.CircuitBreakerCooldown,
+		MaxInflight:            opts.MaxInflight,
+	}
+}
+
+// ProducerConfig.producerOpts - the options to create a producer with this configuration, so it's validated
+// by the same options as CreateProducer. Zero values keep the defaults.
+func (cfg *ProducerConfig) producerOpts() []ProducerOpt {
+	var opts []ProducerOpt
+	if cfg.GenUniqueSuffix {
+		if cfg.UniqueSuffixNumBytes != 0 {
+			opts = append(opts, ProducerGenUniqueSuffixN(cfg.UniqueSuffixNumBytes))
+		} else {
+			opts = append(opts, ProducerGenUniqueSuffix())
+		}
+	}
+	if cfg.HostAndPidSuffix {
+		opts = append(opts, ProducerNameWithHostAndPid())
+	}
+	if cfg.DefaultHeaders != nil {
+		opts = append(opts, WithDefaultHeaders(Headers{MsgHeaders: cfg.DefaultHeaders}))
+	}
+	if cfg.DefaultAckWaitSec != 0 {
+		opts = append(opts, WithDefaultAckWaitSec(cfg.DefaultAckWaitSec))
+	}
+	if cfg.JSONEncoder {
+		opts = append(opts, WithDefaultEncoder(nil))
+	}
+	switch cfg.ContentType {
+	case "":
+	case msgpackContentType:
+		opts = append(opts, WithMsgpackEncoding())
+	default:
+		opts = append(opts, func(*ProducerOpts) error {
+			return fmt.Errorf("unsupported producer content type %q", cfg.ContentType)
+		})
+	}
+	if cfg.ValidationDisabled {
+		opts = append(opts, WithValidationDisabled())
+	}
+	if cfg.EagerSchema {
+		opts = append(opts, WithEagerSchema())
+	}
+	if cfg.LazySchema {
+		opts = append(opts, WithLazySchema())
+	}
+	if cfg.RateLimitPerSecond != 0 || cfg.RateLimitBurst != 0 {
+		opts = append(opts, WithRateLimit(cfg.RateLimitPerSecond, cfg.RateLimitBurst))
+	}
+	if cfg.RateLimitFailFast {
+		opts = append(opts, WithRateLimitFailFast())
+	}
+	if cfg.CircuitBreakerFailures != 0 || cfg.CircuitBreakerCooldown != 0 {
+		opts = append(opts, WithCircuitBreaker(cfg.CircuitBreakerFailures, cfg.CircuitBreakerCooldown))
+	}
+	if cfg.MaxInflight != 0 {
+		opts = append(opts, WithMaxInflight(cfg.MaxInflight))
+	}
+	return opts
+}
+
+func copyHeaders(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	copied := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		copied[k] = append([]string(nil), v...)
+	}
+	return copied
 }
 
 type Notification struct {
@@ -124,55 +327,135 @@ type ProducerOpt func(*ProducerOpts) error
 
 // getDefaultProducerOpts - returns default configuration options for producer creation.
 func getDefaultProducerOpts() ProducerOpts {
-	return ProducerOpts{GenUniqueSuffix: false}
+	return ProducerOpts{GenUniqueSuffix: false, UniqueSuffixNumBytes: defaultUniqueSuffixNumBytes, DefaultAckWaitSec: defaultAckWaitSec}
 }
 
-func extendNameWithRandSuffix(name string) (string, error) {
-	suffix, err := randomHex(4)
+func extendNameWithRandSuffix(name string, numBytes int) (string, error) {
+	suffix, err := randomHex(numBytes)
 	if err != nil {
 		return "", memphisError(err)
 	}
 	return name + "_" + suffix, err
 }
 
+// extendNameWithHostAndPid - extends a name with the hostname and process id, characters of the hostname
+// that aren't allowed in names are replaced with '-'.
+func extendNameWithHostAndPid(name string) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+	hostname = strings.Map(func(ch rune) rune {
+		if (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == '-' || ch == '.' {
+			return ch
+		}
+		return '-'
+	}, strings.ToLower(hostname))
+	return fmt.Sprintf("%v_%v_%v", name, hostname, os.Getpid())
+}
+
 // CreateProducer - creates a producer.
 func (c *Conn) CreateProducer(stationName, name string, opts ...ProducerOpt) (*Producer, error) {
-	name = strings.ToLower(name)
+	if err := validateName(stationName, "station"); err != nil {
+		return nil, memphisError(err)
+	}
+	if err := validateName(name, "producer"); err != nil {
+		return nil, memphisError(err)
+	}
 	defaultOpts := getDefaultProducerOpts()
-	var err error
 	for _, opt := range opts {
-		if err = opt(&defaultOpts); err != nil {
+		if err := opt(&defaultOpts); err != nil {
 			return nil, memphisError(err)
 		}
 	}
 
+	return c.createProducer(stationName, name, defaultOpts)
+}
+
+// CreateProducerFromConfig - creates a producer with a configuration returned by Producer.Config,
+// invalid configurations fail like the matching producer options would.
+func (c *Conn) CreateProducerFromConfig(cfg ProducerConfig) (*Producer, error) {
+	return c.CreateProducer(cfg.StationName, cfg.Name, cfg.producerOpts()...)
+}
+
+// Producer.Config - the producer's configuration, its name doesn't include the generated suffix.
+func (p *Producer) Config() ProducerConfig {
+	cfg := p.config
+	cfg.DefaultHeaders = copyHeaders(cfg.DefaultHeaders)
+	return cfg
+}
+
+func (c *Conn) createProducer(stationName, name string, defaultOpts ProducerOpts) (*Producer, error) {
+	name = strings.ToLower(name)
+	var err error
 	nameWithoutSuffix := name
-	if defaultOpts.GenUniqueSuffix {
-		name, err = extendNameWithRandSuffix(name)
+	if defaultOpts.HostAndPidSuffix {
+		name = extendNameWithHostAndPid(name)
+		if err := validateName(name, "producer"); err != nil {
+			return nil, memphisError(err)
+		}
+	} else if defaultOpts.GenUniqueSuffix {
+		name, err = extendNameWithRandSuffix(name, defaultOpts.UniqueSuffixNumBytes)
 		if err != nil {
 			return nil, memphisError(err)
 		}
 	}
 
 	p := Producer{
-		Name:        name,
-		stationName: getInternalName(stationName),
-		conn:        c,
-		realName:    nameWithoutSuffix,
+		Name:               name,
+		stationName:        getInternalName(stationName),
+		conn:               c,
+		realName:           nameWithoutSuffix,
+		defaultHeaders:     defaultOpts.DefaultHeaders.MsgHeaders,
+		defaultAckWaitSec:  defaultOpts.DefaultAckWaitSec,
+		encoder:            defaultOpts.DefaultEncoder,
+		validationDisabled: defaultOpts.ValidationDisabled,
+		eagerSchema:        defaultOpts.EagerSchema,
+		lazySchema:         defaultOpts.LazySchema,
+		rateLimitFailFast:  defaultOpts.RateLimitFailFast,
+		contentType:        defaultOpts.ContentType,
+		interceptors:       defaultOpts.Interceptors,
+		config:             defaultOpts.config(stationName, nameWithoutSuffix),
 	}
-
-	err = c.listenToSchemaUpdates(stationName)
-	if err != nil {
-		return nil, memphisError(err)
+	if defaultOpts.RateLimitPerSecond > 0 {
+		p.rateLimiter = newRateLimiter(defaultOpts.RateLimitPerSecond, defaultOpts.RateLimitBurst)
+	}
+	if defaultOpts.CircuitBreakerFailures > 0 {
+		p.circuitBreaker = newCircuitBreaker(defaultOpts.CircuitBreakerFailures, defaultOpts.CircuitBreakerCooldown)
+	}
+	if defaultOpts.MaxInflight > 0 {
+		p.inflight = make(chan struct{}, defaultOpts.MaxInflight)
+	}
+	if defaultOpts.jsonEncoder {
+		p.encoder = c.marshalJSON
+		p.jsonEncoder = true
 	}
 
-	if err = c.create(&p); err != nil {
-		if err := c.removeSchemaUpdatesListener(stationName); err != nil {
+	if !p.lazySchema {
+		err = c.listenToConfirmedSchemaUpdates(stationName, nil)
+		if err == nil {
+			p.schemaListening = true
+		} else if !c.fallBackToRawBytes(err) {
 			return nil, memphisError(err)
 		}
+	}
+
+	if err = c.requestProducerCreation(&p); err != nil {
+		if p.schemaListening {
+			if err := c.removeSchemaUpdatesListener(stationName); err != nil {
+				return nil, memphisError(err)
+			}
+		}
 		return nil, memphisError(err)
 	}
+
+	if p.eagerSchema {
+		if err = c.compileSchema(stationName); err != nil {
+			return nil, memphisError(joinErrors(err, p.Destroy()))
+		}
+	}
 	c.cacheProducer(&p)
+	c.trackProducer(&p)
 
 	return &p, nil
 }
@@ -192,15 +475,105 @@ func (c *Conn) Produce(stationName, name string, message any, opts []ProducerOpt
 	return p.Produce(message, pOpts...)
 }
 
+// Conn.ProduceFanOut - produce the same message to several stations, best effort (not atomic).
+// A cached producer is used per station and created on first use, each station validates the message against its own schema.
+// The returned errors match the stations by index, nil for stations the message was produced to.
+func (c *Conn) ProduceFanOut(stations []string, message any, opts ...ProduceOpt) []error {
+	errs := make([]error, len(stations))
+	producers := make([]*Producer, len(stations))
+	name := fanOutProducerName(c.ConnId)
+	for i, stationName := range stations {
+		p, err := c.getProducerFromCache(stationName, name)
+		if err != nil {
+			p, err = c.CreateProducer(stationName, name)
+		}
+		if err != nil {
+			errs[i] = memphisError(err)
+			continue
+		}
+		producers[i] = p
+	}
+
+	var wg sync.WaitGroup
+	for i, p := range producers {
+		if p == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, p *Producer) {
+			defer wg.Done()
+			errs[i] = p.Produce(message, opts...)
+		}(i, p)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func fanOutProducerName(connId string) string {
+	return "fan_out_" + strings.ToLower(connId)
+}
+
+// Station.Produce - produce a message to the station with a producer created on first use and cached by the connection,
+// for producing from many call sites without managing producers. The producer is destroyed when the connection is closed.
+// All call sites share the producer, for high throughput create and manage producers instead.
+func (s *Station) Produce(message any, opts ...ProduceOpt) error {
+	p, err := s.conn.stationProducer(s.Name)
+	if err != nil {
+		return memphisError(err)
+	}
+	return p.Produce(message, opts...)
+}
+
+// Conn.stationProducer - the connection's producer of the station used by Station.Produce, created on first use.
+func (c *Conn) stationProducer(stationName string) (*Producer, error) {
+	c.stationProducersMu.Lock()
+	defer c.stationProducersMu.Unlock()
+
+	name := stationProducerName(c.ConnId)
+	if p, err := c.getProducerFromCache(stationName, name); err == nil {
+		return p, nil
+	}
+	return c.CreateProducer(stationName, name)
+}
+
+// Conn.destroyStationProducers - destroys the producers created by Station.Produce.
+func (c *Conn) destroyStationProducers() {
+	c.stationProducersMu.Lock()
+	name := stationProducerName(c.ConnId)
+	var producers []*Producer
+	c.producersMapMu.RLock()
+	for _, p := range c.getProducersMap() {
+		if p.realName == name {
+			producers = append(producers, p)
+		}
+	}
+	c.producersMapMu.RUnlock()
+	c.stationProducersMu.Unlock()
+
+	for _, p := range producers {
+		p.Destroy()
+	}
+}
+
+func stationProducerName(connId string) string {
+	return "station_" + strings.ToLower(connId)
+}
+
 func (c *Conn) cacheProducer(p *Producer) {
+	c.producersMapMu.Lock()
+	defer c.producersMapMu.Unlock()
 	pm := c.getProducersMap()
 	pm.setProducer(p)
 }
 
+// Conn.unCacheProducer - removes p from the producers cache, another producer cached under the same station and name is kept.
 func (c *Conn) unCacheProducer(p *Producer) {
+	c.producersMapMu.Lock()
+	defer c.producersMapMu.Unlock()
 	pn := fmt.Sprintf("%s_%s", p.stationName, p.realName)
 	pm := c.getProducersMap()
-	if pm.getProducer(pn) == nil {
+	if pm.getProducer(pn) == p {
 		pm.unsetProducer(pn)
 	}
 }
@@ -209,6 +582,8 @@ func (c *Conn) getProducerFromCache(stationName, name string) (*Producer, error)
 	stationName = getInternalName(stationName)
 	name = strings.ToLower(name)
 	pn := fmt.Sprintf("%s_%s", stationName, name)
+	c.producersMapMu.RLock()
+	defer c.producersMapMu.RUnlock()
 	pm := c.getProducersMap()
 	if pm.getProducer(pn) == nil {
 		return nil, fmt.Errorf("%s not exists on the map", pn)
@@ -237,9 +612,27 @@ func (p *Producer) getCreationReq() any {
 	}
 }
 
+// Conn.requestProducerCreation - sends the producer's creation request, the broker's reply carries the station's schema
+// so it's bounded by the schema fetch timeout when one is set.
+func (c *Conn) requestProducerCreation(p *Producer) error {
+	timeout := c.opts.SchemaFetchTimeout
+	if timeout <= 0 {
+		return c.create(p)
+	}
+	return schemaFetchErr(c.createWithTimeout(p, timeout), timeout)
+}
+
+// schemaFetchErr - err of a schema fetch that waited up to timeout, categorized as ErrSchemaFetchTimeout on timeout.
+func schemaFetchErr(err error, timeout time.Duration) error {
+	if errors.Is(err, nats.ErrTimeout) {
+		return memphisError(&categorizedErr{category: ErrSchemaFetchTimeout, cause: fmt.Errorf("no reply within %v: %w", timeout, err)})
+	}
+	return err
+}
+
 func (p *Producer) handleCreationResp(resp []byte) error {
 	cr := &createProducerResp{}
-	err := json.Unmarshal(resp, cr)
+	err := p.conn.unmarshalJSON(resp, cr)
 	if err != nil {
 		// unmarshal failed, we may be dealing with an old broker
 		return defaultHandleCreationResp(resp)
@@ -251,10 +644,14 @@ func (p *Producer) handleCreationResp(resp []byte) error {
 
 	sn := getInternalName(p.stationName)
 
-	p.conn.stationUpdatesMu.Lock()
-	sd := &p.conn.stationUpdatesSubs[sn].schemaDetails
-	sd.handleSchemaUpdateInit(cr.SchemaUpdateInit)
-	p.conn.stationUpdatesMu.Unlock()
+	if p.schemaListening {
+		p.conn.stationUpdatesMu.Lock()
+		sd := &p.conn.stationUpdatesSubs[sn].schemaDetails
+		sd.setSchemaUpdateInit(cr.SchemaUpdateInit)
+		p.conn.stationUpdatesMu.Unlock()
+	} else {
+		p.pendingSchemaInit = cr.SchemaUpdateInit
+	}
 
 	p.conn.configUpdatesMu.Lock()
 	cu := &p.conn.configUpdatesSub
@@ -273,18 +670,24 @@ func (p *Producer) getDestructionReq() any {
 	return removeProducerReq{Name: p.Name, StationName: p.stationName, Username: p.conn.username}
 }
 
-// Destroy - destoy this producer.
+// Destroy - destoy this producer, the producer is destroyed on the broker even if removing its schema updates listener fails,
+// in which case all errors are returned.
 func (p *Producer) Destroy() error {
-	if err := p.conn.removeSchemaUpdatesListener(p.stationName); err != nil {
-		return memphisError(err)
+	var listenerErr error
+	p.schemaMu.Lock()
+	if p.schemaListening {
+		listenerErr = p.conn.removeSchemaUpdatesListener(p.stationName)
+		p.schemaListening = false
 	}
-	err := p.conn.destroy(p)
-	if err != nil {
-		return err
+	p.schemaMu.Unlock()
+
+	if err := p.conn.destroy(p); err != nil {
+		return memphisError(joinErrors(listenerErr, err))
 	}
 
 	p.conn.unCacheProducer(p)
-	return nil
+	p.conn.untrackProducer(p)
+	return memphisError(listenerErr)
 }
 
 type Headers struct {
@@ -293,25 +696,53 @@ type Headers struct {
 
 // ProduceOpts - configuration options for produce operations.
 type ProduceOpts struct {
-	Message      any
-	AckWaitSec   int
-	MsgHeaders   Headers
-	AsyncProduce bool
+	Message           any
+	AckWaitSec        int
+	MsgHeaders        Headers
+	AsyncProduce      bool
+	MaxAttempts       int
+	Backoff           BackoffStrategy
+	Context           context.Context
+	SkipValidation    bool
+	MessageStructName string
+	Timeout           time.Duration
+	TTL               time.Duration
+	DeliverAfter      time.Duration
+	EventTime         time.Time
+	RawHeaders        nats.Header
+	BinaryHeaders     map[string][]byte
+	ContentType       string
+	PreEncoded        bool
+	SubjectKey        string
+	IdempotencyScope  string
+	schema            *schemaDetails
+	replyTo           string
+	correlationID     string
+	ctx               context.Context
+	onPubAckFuture    func(nats.PubAckFuture)
+	onPubAck          func(*nats.PubAck)
+	onLatency         func(time.Duration)
 }
 
+// BackoffStrategy - returns the time to wait after a failed attempt, attempts are counted from 1.
+type BackoffStrategy func(attempt int) time.Duration
+
 // ProduceOpt - a function on the options for produce operations.
 type ProduceOpt func(*ProduceOpts) error
 
 // getDefaultProduceOpts - returns default configuration options for produce operations.
 func getDefaultProduceOpts() ProduceOpts {
 	msgHeaders := make(map[string][]string)
-	return ProduceOpts{AckWaitSec: 15, MsgHeaders: Headers{MsgHeaders: msgHeaders}, AsyncProduce: false}
+	return ProduceOpts{AckWaitSec: defaultAckWaitSec, MsgHeaders: Headers{MsgHeaders: msgHeaders}, AsyncProduce: false, MaxAttempts: 1}
 }
 
 // Producer.Produce - produces a message into a station. message is of type []byte/protoreflect.ProtoMessage in case it is a schema validated station
 func (p *Producer) Produce(message any, opts ...ProduceOpt) error {
 	defaultOpts := getDefaultProduceOpts()
 	defaultOpts.Message = message
+	if p.defaultAckWaitSec > 0 {
+		defaultOpts.AckWaitSec = p.defaultAckWaitSec
+	}
 
 	for _, opt := range opts {
 		if opt != nil {
@@ -321,7 +752,95 @@ func (p *Producer) Produce(message any, opts ...ProduceOpt) error {
 		}
 	}
 
-	return defaultOpts.produce(p)
+	return p.produceChain()(message, &defaultOpts)
+}
+
+// Producer.ProduceSeq - produces a message synchronously and returns the stream sequence the broker stored it at,
+// taken from the broker's ack. For a duplicate dropped by the broker's deduplication (same msg-id) the sequence
+// of the stored original is returned. Can't be combined with AsyncProduce.
+func (p *Producer) ProduceSeq(message any, opts ...ProduceOpt) (uint64, error) {
+	var seq uint64
+	opts = append(opts, func(opts *ProduceOpts) error {
+		if opts.AsyncProduce {
+			return errors.New("ProduceSeq can't be used with AsyncProduce")
+		}
+		opts.onPubAck = func(ack *nats.PubAck) {
+			seq = ack.Sequence
+		}
+		return nil
+	})
+	if err := p.Produce(message, opts...); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// produceChain - the producer's interceptors wrapping the produce, the first interceptor is the outermost.
+func (p *Producer) produceChain() ProduceFunc {
+	produce := func(message any, opts *ProduceOpts) error {
+		opts.Message = message
+		return opts.produce(p)
+	}
+	for i := len(p.interceptors) - 1; i >= 0; i-- {
+		produce = p.interceptors[i](produce)
+	}
+	return produce
+}
+
+// ProduceGroup - a group of async produces that can be waited on together.
+type ProduceGroup struct {
+	p       *Producer
+	mu      sync.Mutex
+	futures []nats.PubAckFuture
+	errs    []error
+}
+
+// Producer.NewProduceGroup - creates a group of async produces of this producer.
+func (p *Producer) NewProduceGroup() *ProduceGroup {
+	return &ProduceGroup{p: p}
+}
+
+// ProduceGroup.Produce - produces a message asynchronously as part of the group, errors returned before
+// the message is published are returned and also reported by Wait.
+func (g *ProduceGroup) Produce(message any, opts ...ProduceOpt) error {
+	opts = append(opts, AsyncProduce(), func(opts *ProduceOpts) error {
+		opts.onPubAckFuture = g.track
+		return nil
+	})
+	err := g.p.Produce(message, opts...)
+	if err != nil {
+		g.mu.Lock()
+		g.errs = append(g.errs, err)
+		g.mu.Unlock()
+	}
+	return err
+}
+
+func (g *ProduceGroup) track(paf nats.PubAckFuture) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.futures = append(g.futures, paf)
+}
+
+// ProduceGroup.Wait - waits until all produces of the group are acked or failed, or until ctx is done,
+// and returns the failures joined. The group is reset and can be reused afterwards.
+func (g *ProduceGroup) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	futures, errs := g.futures, g.errs
+	g.futures, g.errs = nil, nil
+	g.mu.Unlock()
+
+	for _, paf := range futures {
+		select {
+		case <-paf.Ok():
+		case err := <-paf.Err():
+			errs = append(errs, memphisError(err))
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			return memphisError(joinErrors(errs...))
+		}
+	}
+	return memphisError(joinErrors(errs...))
 }
 
 func (hdr *Headers) validateHeaderKey(key string) error {
@@ -331,91 +850,458 @@ func (hdr *Headers) validateHeaderKey(key string) error {
 	return nil
 }
 
+// NewHeaders - create headers from a map of keys to single values.
+func NewHeaders(m map[string]string) (Headers, error) {
+	multi := make(map[string][]string, len(m))
+	for key, value := range m {
+		multi[key] = []string{value}
+	}
+	return NewMultiHeaders(multi)
+}
+
+// NewMultiHeaders - create headers from a map of keys to values, the first invalid key (in sorted order) is reported.
+func NewMultiHeaders(m map[string][]string) (Headers, error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hdr := Headers{}
+	hdr.New()
+	for _, key := range keys {
+		if err := hdr.validateHeaderKey(key); err != nil {
+			return Headers{}, memphisError(fmt.Errorf("header key %q: %w", key, err))
+		}
+		hdr.MsgHeaders[key] = append([]string{}, m[key]...)
+	}
+	return hdr, nil
+}
+
+// HeadersFromNats - create headers from NATS message headers, multi-value entries are kept. Keys starting with $memphis
+// are rejected like in NewMultiHeaders, since they are reserved for the client.
+func HeadersFromNats(h nats.Header) (Headers, error) {
+	return NewMultiHeaders(h)
+}
+
+// Headers.ToNats - copy the headers to NATS message headers, for publishing with a plain NATS client.
+func (hdr Headers) ToNats() nats.Header {
+	h := make(nats.Header, len(hdr.MsgHeaders))
+	for key, values := range hdr.MsgHeaders {
+		h[key] = append([]string{}, values...)
+	}
+	return h
+}
+
+// CanonicalHeaderKey - returns the canonical form of a header key, as used by http.Header,
+// useful for keeping keys consistent when mapping HTTP headers to message headers.
+func CanonicalHeaderKey(key string) string {
+	return textproto.CanonicalMIMEHeaderKey(key)
+}
+
 func (hdr *Headers) New() {
 	hdr.MsgHeaders = map[string][]string{}
 }
 
+// Headers.Add - appends a value to the values of a header key, keys are stored verbatim (case-sensitive).
 func (hdr *Headers) Add(key, value string) error {
 	err := hdr.validateHeaderKey(key)
 	if err != nil {
 		return memphisError(err)
 	}
 
+	hdr.MsgHeaders[key] = append(hdr.MsgHeaders[key], value)
+	return nil
+}
+
+// Headers.Set - sets a header key to a single value, replacing any existing values.
+func (hdr *Headers) Set(key, value string) error {
+	err := hdr.validateHeaderKey(key)
+	if err != nil {
+		return memphisError(err)
+	}
+
 	hdr.MsgHeaders[key] = []string{value}
 	return nil
 }
 
+// Headers.Get - get the values of a header key.
+func (hdr *Headers) Get(key string) ([]string, bool) {
+	values, ok := hdr.MsgHeaders[key]
+	return values, ok
+}
+
+// Headers.Remove - removes a header key and its values.
+func (hdr *Headers) Remove(key string) error {
+	err := hdr.validateHeaderKey(key)
+	if err != nil {
+		return memphisError(err)
+	}
+
+	delete(hdr.MsgHeaders, key)
+	return nil
+}
+
 // ProducerOpts.produce - produces a message into a station using a configuration struct.
-func (opts *ProduceOpts) produce(p *Producer) error {
-	opts.MsgHeaders.MsgHeaders["$memphis_connectionId"] = []string{p.conn.ConnId}
-	opts.MsgHeaders.MsgHeaders["$memphis_producedBy"] = []string{p.Name}
+func (opts *ProduceOpts) produce(p *Producer) (err error) {
+	if p.circuitBreaker != nil && !p.circuitBreaker.allow() {
+		err = memphisError(ErrCircuitOpen)
+		p.conn.recordProduce(err)
+		return err
+	}
+
+	published := false
+	defer func() {
+		// published async produces are recorded by awaitAck once their ack arrives
+		if opts.AsyncProduce && err == nil {
+			return
+		}
+		p.conn.recordProduce(err)
+		if p.circuitBreaker != nil {
+			p.circuitBreaker.done(published, err)
+		}
+	}()
 
-	data, err := p.validateMsg(opts.Message, opts.MsgHeaders.MsgHeaders)
+	opts.ctx = opts.Context
+	if opts.ctx == nil {
+		opts.ctx = context.Background()
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		opts.ctx, cancel = context.WithTimeout(opts.ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	if err := p.waitRateLimit(opts.ctx); err != nil {
+		return memphisError(opts.contextErr(err))
+	}
+
+	opts.MsgHeaders.MsgHeaders = p.buildMsgHeaders(opts.MsgHeaders.MsgHeaders, opts.RawHeaders)
+	if opts.IdempotencyScope != "" {
+		scopeMsgIds(opts.MsgHeaders.MsgHeaders, opts.IdempotencyScope)
+	}
+	if opts.replyTo != "" {
+		opts.MsgHeaders.MsgHeaders[replyToHeader] = []string{opts.replyTo}
+		opts.MsgHeaders.MsgHeaders[correlationIdHeader] = []string{opts.correlationID}
+	}
+	if opts.TTL > 0 {
+		opts.MsgHeaders.MsgHeaders[ttlHeader] = []string{strconv.FormatInt(opts.TTL.Milliseconds(), 10)}
+	}
+	if opts.DeliverAfter > 0 {
+		deliverAt := time.Now().Add(opts.DeliverAfter).UnixMilli()
+		opts.MsgHeaders.MsgHeaders[deliverAtHeader] = []string{strconv.FormatInt(deliverAt, 10)}
+	}
+	if !opts.EventTime.IsZero() {
+		opts.MsgHeaders.MsgHeaders[eventTimeHeader] = []string{strconv.FormatInt(opts.EventTime.UnixMilli(), 10)}
+	}
+	for key, value := range opts.BinaryHeaders {
+		opts.MsgHeaders.MsgHeaders[binaryHeaderPrefix+key] = []string{base64.StdEncoding.EncodeToString(value)}
+	}
+
+	var data []byte
+	var sd schemaDetails
+	if opts.SkipValidation || p.validationDisabled {
+		data, err = p.rawMsgBytes(opts.Message)
+	} else if opts.schema != nil {
+		// failures of a per message schema aren't the station's schema failures, so they aren't sent to the DLS
+		sd = *opts.schema
+		sd.marshal, sd.unmarshal = p.conn.opts.JSONMarshaler, p.conn.opts.JSONUnmarshaler
+		data, err = p.validateMsgWithSchema(sd, opts.Message, opts.MessageStructName, opts.PreEncoded)
+	} else {
+		data, sd, err = p.validateMsg(opts.Message, opts.MsgHeaders.MsgHeaders, opts.MessageStructName, opts.PreEncoded)
+	}
 	if err != nil {
 		return memphisError(err)
 	}
+	schemaType := sd.schemaType
+	// the version is stamped only for messages validated against the station's schema, not for unsupported schema
+	// types passed through or messages validated against a per message schema whose versions aren't the station's
+	if schemaType != "" && isSupportedSchemaType(schemaType) && opts.schema == nil {
+		opts.MsgHeaders.MsgHeaders[schemaVersionHeader] = []string{strconv.Itoa(sd.activeVersion.VersionNumber)}
+	}
+
+	if opts.ContentType != "" {
+		opts.MsgHeaders.MsgHeaders[contentTypeKey] = []string{opts.ContentType}
+	} else if _, ok := opts.MsgHeaders.MsgHeaders[contentTypeKey]; !ok {
+		if ct := p.defaultContentType(opts.Message, schemaType); ct != "" {
+			opts.MsgHeaders.MsgHeaders[contentTypeKey] = []string{ct}
+		}
+	}
+	if err := p.conn.validateHeaders(opts.MsgHeaders.MsgHeaders); err != nil {
+		return memphisError(err)
+	}
+
+	if err := p.conn.validateMsgSize(data); err != nil {
+		return memphisError(err)
+	}
 
 	natsMessage := nats.Msg{
 		Header:  opts.MsgHeaders.MsgHeaders,
-		Subject: getInternalName(p.stationName) + ".final",
+		Subject: p.Subject() + subjectKeySuffix(opts.SubjectKey),
 		Data:    data,
 	}
 
-	stallWaitDuration := time.Second * time.Duration(opts.AckWaitSec)
-	paf, err := p.conn.brokerPublish(&natsMessage, nats.StallWait(stallWaitDuration))
+	published = true
+	attempt := 1
+	for {
+		err = opts.publish(p, &natsMessage)
+		if err == nil || attempt >= opts.MaxAttempts || !isTransientProduceErr(err) {
+			break
+		}
+		if err := opts.waitBackoff(attempt); err != nil {
+			return memphisError(fmt.Errorf("produce failed after %v attempts: %w", attempt, err))
+		}
+		attempt++
+	}
+	if err != nil && opts.MaxAttempts > 1 {
+		return memphisError(fmt.Errorf("produce failed after %v attempts: %w", attempt, err))
+	}
+	if err == nil {
+		p.conn.auditProduce(p, &natsMessage)
+	}
+
+	return memphisError(err)
+}
+
+func (opts *ProduceOpts) publish(p *Producer, natsMessage *nats.Msg) error {
+	stallWaitDuration, err := opts.stallWait()
 	if err != nil {
 		return memphisError(err)
 	}
+	if opts.AsyncProduce {
+		if err := p.acquireInflight(opts.ctx); err != nil {
+			return memphisError(opts.contextErr(err))
+		}
+	}
+	start := time.Now()
+	paf, err := p.conn.brokerPublish(natsMessage, nats.StallWait(stallWaitDuration))
+	if err != nil {
+		if opts.AsyncProduce {
+			p.releaseInflight()
+		}
+		return memphisError(categorizePublishErr(err))
+	}
 
 	if opts.AsyncProduce {
+		go opts.awaitAck(p, paf, start, time.Second*time.Duration(opts.AckWaitSec))
+		if opts.onPubAckFuture != nil {
+			opts.onPubAckFuture(paf)
+		}
 		return nil
 	}
 
 	select {
-	case <-paf.Ok():
+	case ack := <-paf.Ok():
+		if opts.onLatency != nil {
+			opts.onLatency(time.Since(start))
+		}
+		if opts.onPubAck != nil {
+			opts.onPubAck(ack)
+		}
 		return nil
 	case err = <-paf.Err():
-		return memphisError(err)
+		return memphisError(categorizePublishErr(err))
+	case <-opts.ctx.Done():
+		return memphisError(opts.contextErr(opts.ctx.Err()))
 	}
 }
 
-func (p *Producer) sendNotification(title string, msg string, code string, msgType string) {
-	notification := Notification{
-		Title: title,
-		Msg:   msg,
-		Type:  msgType,
-		Code:  code,
+// ProduceOpts.awaitAck - waits up to ackWait for the ack of an async produce, then releases its in-flight slot
+// so a lost ack doesn't hold it forever, reports its latency if acked and records its result in the connection's
+// stats and the producer's circuit breaker. A produce not acked within ackWait is counted as a broker timeout.
+func (opts *ProduceOpts) awaitAck(p *Producer, paf nats.PubAckFuture, start time.Time, ackWait time.Duration) {
+	timer := time.NewTimer(ackWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-paf.Ok():
+		if opts.onLatency != nil {
+			opts.onLatency(time.Since(start))
+		}
+	case err = <-paf.Err():
+		err = categorizePublishErr(err)
+	case <-timer.C:
+		err = categorizePublishErr(nats.ErrTimeout)
 	}
-	msgToPublish, _ := json.Marshal(notification)
 
-	_ = p.conn.brokerConn.Publish(memphisNotificationsSubject, msgToPublish)
+	p.releaseInflight()
+	p.conn.recordProduce(err)
+	if p.circuitBreaker != nil {
+		p.circuitBreaker.done(true, err)
+	}
 }
 
-func (p *Producer) msgToString(msg any) string {
-	var stringMsg string
-	switch msg.(type) {
-	case []byte:
-		stringMsg = string(msg.([]byte)[:])
-	default:
-		stringMsg = fmt.Sprintf("%v", msg)
+// ProduceOpts.stallWait - how long publishing may stall, AckWaitSec bounded by the time left until the context's deadline.
+func (opts *ProduceOpts) stallWait() (time.Duration, error) {
+	stallWait := time.Second * time.Duration(opts.AckWaitSec)
+	if deadline, ok := opts.ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, opts.contextErr(context.DeadlineExceeded)
+		}
+		if remaining < stallWait {
+			stallWait = remaining
+		}
 	}
+	return stallWait, nil
+}
 
-	return stringMsg
+// ProduceOpts.contextErr - converts a context error caused by the produce timeout to ErrProduceTimeout.
+func (opts *ProduceOpts) contextErr(err error) error {
+	callerCtxDone := opts.Context != nil && opts.Context.Err() != nil
+	if opts.Timeout > 0 && errors.Is(err, context.DeadlineExceeded) && !callerCtxDone {
+		return fmt.Errorf("%w: after %v", ErrProduceTimeout, opts.Timeout)
+	}
+	return err
 }
 
-func (p *Producer) sendMsgToDls(msg any, headers map[string][]string, err error) {
-	internStation := getInternalName(p.stationName)
-	if p.conn.configUpdatesSub.StationSchemaverseToDlsMap[internStation] {
-		msgToSend := p.msgToString(msg)
-		timeSent := time.Now()
-		id := GetDlsMsgId(internStation, p.Name, time.Now().String())
-		headersForDls := make(map[string]string)
-		for k, v := range headers {
-			concat := strings.Join(v, " ")
-			headersForDls[k] = concat
-		}
-		schemaFailMsg := &DlsMessage{
-			ID:          id,
+// ProduceOpts.waitBackoff - waits before the next produce attempt, returns early if the context is done.
+func (opts *ProduceOpts) waitBackoff(attempt int) error {
+	var wait time.Duration
+	if opts.Backoff != nil {
+		wait = opts.Backoff(attempt)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-opts.ctx.Done():
+		return opts.contextErr(opts.ctx.Err())
+	}
+}
+
+// categorizePublishErr - wraps broker timeouts, missing responders and messages exceeding the broker's max payload
+// with ErrBrokerTimeout, ErrNoResponders and ErrMessageTooLarge.
+func categorizePublishErr(err error) error {
+	switch {
+	case errors.Is(err, nats.ErrNoResponders):
+		return &categorizedErr{category: ErrNoResponders, cause: err}
+	case errors.Is(err, nats.ErrTimeout):
+		return &categorizedErr{category: ErrBrokerTimeout, cause: err}
+	case errors.Is(err, nats.ErrMaxPayload):
+		return &categorizedErr{category: ErrMessageTooLarge, cause: err}
+	}
+	return err
+}
+
+// isTransientProduceErr - whether a publish error may succeed on retry.
+func isTransientProduceErr(err error) bool {
+	return errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, nats.ErrNoResponders) ||
+		strings.Contains(err.Error(), "stalled with too many outstanding")
+}
+
+// ExponentialBackoff - a backoff strategy doubling the wait after each attempt, starting from initial and capped at max.
+func ExponentialBackoff(initial, max time.Duration) BackoffStrategy {
+	return func(attempt int) time.Duration {
+		wait := initial
+		for i := 1; i < attempt && wait < max; i++ {
+			wait *= 2
+		}
+		if wait > max {
+			wait = max
+		}
+		return wait
+	}
+}
+
+// waitRateLimit - takes a token from the producer's rate limiter, blocking until one is available unless fail fast is set.
+func (p *Producer) waitRateLimit(ctx context.Context) error {
+	if p.rateLimiter == nil {
+		return nil
+	}
+	if p.rateLimitFailFast {
+		if !p.rateLimiter.allow() {
+			return ErrRateLimited
+		}
+		return nil
+	}
+	return p.rateLimiter.wait(ctx)
+}
+
+// buildMsgHeaders - merges the producer's default headers with the message headers and raw headers,
+// message headers override default ones, raw headers override both and memphis headers override all.
+func (p *Producer) buildMsgHeaders(msgHeaders, rawHeaders map[string][]string) map[string][]string {
+	headers := make(map[string][]string, len(p.defaultHeaders)+len(msgHeaders)+len(rawHeaders)+2)
+	for k, v := range p.defaultHeaders {
+		headers[k] = v
+	}
+	for k, v := range msgHeaders {
+		headers[k] = v
+	}
+	for k, v := range rawHeaders {
+		headers[k] = v
+	}
+	headers["$memphis_connectionId"] = []string{p.conn.ConnId}
+	headers["$memphis_producedBy"] = []string{p.Name}
+	return headers
+}
+
+// validateHeaders - verifies the headers are within the connection's size and count limits, memphis headers included.
+func (c *Conn) validateHeaders(headers map[string][]string) error {
+	size, count := 0, 0
+	for k, values := range headers {
+		for _, v := range values {
+			size += len(k) + len(v)
+			count++
+		}
+	}
+	if count > c.opts.MaxHeadersCount {
+		return fmt.Errorf("%w: %v headers, max is %v", ErrTooManyHeaders, count, c.opts.MaxHeadersCount)
+	}
+	if size > c.opts.MaxHeadersSize {
+		return fmt.Errorf("%w: %v bytes, max is %v", ErrHeadersTooLarge, size, c.opts.MaxHeadersSize)
+	}
+	return nil
+}
+
+// validateMsgSize - verifies the payload doesn't exceed the broker's max payload.
+func (c *Conn) validateMsgSize(data []byte) error {
+	if c.maxPayload > 0 && int64(len(data)) > c.maxPayload {
+		return fmt.Errorf("%w: %v bytes, max is %v", ErrMessageTooLarge, len(data), c.maxPayload)
+	}
+	return nil
+}
+
+func (p *Producer) sendNotification(title string, msg string, code string, msgType string) {
+	notification := Notification{
+		Title: title,
+		Msg:   msg,
+		Type:  msgType,
+		Code:  code,
+	}
+	msgToPublish, _ := p.conn.marshalJSON(notification)
+
+	_ = p.conn.brokerConn.Publish(memphisNotificationsSubject, msgToPublish)
+}
+
+func (p *Producer) msgToString(msg any) string {
+	var stringMsg string
+	switch msg.(type) {
+	case []byte:
+		stringMsg = string(msg.([]byte)[:])
+	default:
+		stringMsg = fmt.Sprintf("%v", msg)
+	}
+
+	return stringMsg
+}
+
+func (p *Producer) sendMsgToDls(msg any, headers map[string][]string, err error) {
+	internStation := getInternalName(p.stationName)
+	if p.conn.configUpdatesSub.StationSchemaverseToDlsMap[internStation] {
+		msgToSend := p.msgToString(msg)
+		timeSent := time.Now()
+		id := GetDlsMsgId(internStation, p.Name, time.Now().String())
+		headersForDls := make(map[string]string)
+		for k, v := range headers {
+			concat := strings.Join(v, " ")
+			headersForDls[k] = concat
+		}
+		schemaFailMsg := &DlsMessage{
+			ID:          id,
 			StationName: internStation,
 			Producer: ProducerDetails{
 				Name:         p.Name,
@@ -428,7 +1314,7 @@ func (p *Producer) sendMsgToDls(msg any, headers map[string][]string, err error)
 			},
 			CreationDate: timeSent,
 		}
-		msgToPublish, _ := json.Marshal(schemaFailMsg)
+		msgToPublish, _ := p.conn.marshalJSON(schemaFailMsg)
 		_ = p.conn.brokerConn.Publish(GetDlsSubject("schema", internStation, id), msgToPublish)
 
 		if p.conn.configUpdatesSub.ClusterConfigurations["send_notification"] {
@@ -437,39 +1323,248 @@ func (p *Producer) sendMsgToDls(msg any, headers map[string][]string, err error)
 	}
 }
 
-func (p *Producer) validateMsg(msg any, headers map[string][]string) ([]byte, error) {
+// validateMsg - validates the message against the station's schema, returns the message's bytes and the schema details
+// it was validated against. Pre-encoded []byte messages produced to protobuf stations are trusted without validation.
+func (p *Producer) validateMsg(msg any, headers map[string][]string, msgStructName string, preEncoded bool) ([]byte, schemaDetails, error) {
 	sd, err := p.getSchemaDetails()
 	if err != nil {
-		return nil, memphisError(errors.New("Schema validation has failed: " + err.Error()))
+		return nil, schemaDetails{}, memphisError(errors.New("Schema validation has failed: " + err.Error()))
+	}
+
+	msgBytes, err := p.validateMsgWithSchema(sd, msg, msgStructName, preEncoded)
+	var validationErr *categorizedErr
+	if errors.As(err, &validationErr) && validationErr.category == ErrSchemaValidation {
+		p.sendMsgToDls(msg, headers, validationErr.cause)
+	}
+	if err != nil {
+		return nil, schemaDetails{}, err
 	}
+	return msgBytes, sd, nil
+}
 
+// validateMsgWithSchema - validates the message against sd, schema validation failures are categorized as ErrSchemaValidation.
+func (p *Producer) validateMsgWithSchema(sd schemaDetails, msg any, msgStructName string, preEncoded bool) ([]byte, error) {
 	// empty schema type means there is no schema and validation is not needed
 	// so we just verify the type is byte slice or map[string]interface{}
 	if sd.schemaType == "" {
-		switch msg.(type) {
-		case []byte:
-			return msg.([]byte), nil
-		case map[string]interface{}:
-			return json.Marshal(msg)
-		default:
-			return nil, memphisError(errors.New("Unsupported message type"))
+		return p.rawMsgBytes(msg)
+	}
+
+	if !isSupportedSchemaType(sd.schemaType) {
+		if !p.conn.opts.UnknownSchemaPassthrough {
+			return nil, memphisError(ErrUnsupportedSchemaType{Type: sd.schemaType})
+		}
+		msgBytes, ok := msg.([]byte)
+		if !ok {
+			return nil, memphisError(fmt.Errorf("%w %T: messages to stations with an unsupported schema type have to be []byte", ErrUnsupportedMsgType, msg))
 		}
+		return msgBytes, nil
+	}
 
+	if preEncoded && sd.schemaType == SchemaTypeProtobuf {
+		msgBytes, ok := msg.([]byte)
+		if !ok {
+			return nil, memphisError(fmt.Errorf("%w %T: pre-encoded messages have to be []byte", ErrUnsupportedMsgType, msg))
+		}
+		return msgBytes, nil
 	}
 
-	msgBytes, err := sd.validateMsg(msg)
+	msgBytes, err := sd.validateMsg(msg, msgStructName)
 	if err != nil {
-		p.sendMsgToDls(msg, headers, err)
-		return nil, memphisError(errors.New("Schema validation has failed: " + err.Error()))
+		return nil, memphisError(&categorizedErr{category: ErrSchemaValidation, cause: err})
 	}
 
 	return msgBytes, nil
 }
 
+// defaultContentType - the content type of a message encoded by the client, empty for messages passed as bytes
+// and messages encoded by a custom encoder.
+func (p *Producer) defaultContentType(msg any, schemaType SchemaType) string {
+	switch schemaType {
+	case SchemaTypeProtobuf:
+		return "application/x-protobuf"
+	case SchemaTypeJSON:
+		return "application/json"
+	case SchemaTypeGraphQL:
+		return "application/graphql"
+	}
+	if schemaType != "" {
+		return ""
+	}
+
+	switch msg.(type) {
+	case []byte, string, encoding.BinaryMarshaler, io.Reader:
+		return ""
+	case json.RawMessage:
+		return "application/json"
+	case map[string]interface{}:
+		if p.contentType != "" {
+			return p.contentType
+		}
+		return "application/json"
+	default:
+		if p.contentType != "" {
+			return p.contentType
+		}
+		if p.jsonEncoder {
+			return "application/json"
+		}
+		return ""
+	}
+}
+
+// rawMsgBytes - converts a message to bytes without any schema validation.
+func (p *Producer) rawMsgBytes(msg any) ([]byte, error) {
+	switch msg.(type) {
+	case []byte:
+		return msg.([]byte), nil
+	case json.RawMessage:
+		return msg.(json.RawMessage), nil
+	case string:
+		return []byte(msg.(string)), nil
+	case map[string]interface{}:
+		if p.contentType == msgpackContentType {
+			return p.encoder(msg)
+		}
+		return p.conn.marshalJSON(msg)
+	case encoding.BinaryMarshaler:
+		// the message's own wire format takes precedence over the producer's encoder
+		data, err := msg.(encoding.BinaryMarshaler).MarshalBinary()
+		return data, memphisError(err)
+	case io.Reader:
+		return p.conn.readMsg(msg.(io.Reader))
+	default:
+		if p.encoder != nil {
+			return p.encoder(msg)
+		}
+		return nil, memphisError(fmt.Errorf("%w %T for station %v without a schema: attach a schema to the station, produce []byte/string/map[string]interface{}/encoding.BinaryMarshaler or set an encoder with WithDefaultEncoder", ErrUnsupportedMsgType, msg, p.stationName))
+	}
+}
+
+// readMsg - reads a message from a reader, up to the broker's max payload.
+func (c *Conn) readMsg(r io.Reader) ([]byte, error) {
+	if c.maxPayload <= 0 {
+		return io.ReadAll(r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, c.maxPayload+1))
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	if err := c.validateMsgSize(data); err != nil {
+		return nil, memphisError(err)
+	}
+	return data, nil
+}
+
 func (p *Producer) getSchemaDetails() (schemaDetails, error) {
+	if sd, ok := p.conn.getInjectedSchema(p.stationName); ok {
+		return sd, nil
+	}
+	if err := p.ensureSchemaListener(); err != nil {
+		if p.conn.fallBackToRawBytes(err) {
+			return schemaDetails{}, nil
+		}
+		return schemaDetails{}, memphisError(err)
+	}
 	return p.conn.getSchemaDetails(p.stationName)
 }
 
+// Conn.fallBackToRawBytes - whether producers should produce raw bytes after failing to subscribe with err.
+func (c *Conn) fallBackToRawBytes(err error) bool {
+	return errors.Is(err, ErrSchemaFetchTimeout) && c.opts.SchemaFetchFallback == SchemaFetchRawBytes
+}
+
+// SchemaDetails - the schema attached to a station, as cached by the client.
+type SchemaDetails struct {
+	Name              string
+	Type              SchemaType
+	ActiveVersion     int
+	MessageStructName string
+}
+
+// Producer.SchemaDescriptor - get the raw descriptor and version number of the station's active schema version from the local cache.
+func (p *Producer) SchemaDescriptor() (string, int, error) {
+	sui, err := p.cachedSchema()
+	if err != nil {
+		return "", 0, memphisError(err)
+	}
+	return sui.ActiveVersion.Descriptor, sui.ActiveVersion.VersionNumber, nil
+}
+
+// Producer.SchemaDetails - get the details of the station's schema from the local cache.
+func (p *Producer) SchemaDetails() (SchemaDetails, error) {
+	sui, err := p.cachedSchema()
+	if err != nil {
+		return SchemaDetails{}, memphisError(err)
+	}
+	return SchemaDetails{
+		Name:              sui.SchemaName,
+		Type:              sui.SchemaType,
+		ActiveVersion:     sui.ActiveVersion.VersionNumber,
+		MessageStructName: sui.ActiveVersion.MessageStructName,
+	}, nil
+}
+
+// Producer.HasSchema - whether a schema is attached to the station, read from the local cache.
+// ErrSchemaNotLoaded is returned when the station's schema isn't cached.
+func (p *Producer) HasSchema() (bool, error) {
+	sui, err := p.loadedSchema()
+	if err != nil {
+		return false, memphisError(err)
+	}
+	return sui.SchemaType != "", nil
+}
+
+// cachedSchema - the station's schema from the local cache, without subscribing to schema updates for lazy producers.
+func (p *Producer) cachedSchema() (SchemaUpdateInit, error) {
+	sui, err := p.loadedSchema()
+	if err != nil {
+		return SchemaUpdateInit{}, err
+	}
+	if sui.SchemaType == "" {
+		return SchemaUpdateInit{}, errors.New("no schema is attached to the station")
+	}
+	return sui, nil
+}
+
+// loadedSchema - same as cachedSchema, an empty schema type means no schema is attached to the station.
+func (p *Producer) loadedSchema() (SchemaUpdateInit, error) {
+	p.schemaMu.Lock()
+	listening := p.schemaListening
+	sui := p.pendingSchemaInit
+	p.schemaMu.Unlock()
+
+	if listening {
+		sn := getInternalName(p.stationName)
+		p.conn.stationUpdatesMu.RLock()
+		sus, ok := p.conn.stationUpdatesSubs[sn]
+		if !ok {
+			p.conn.stationUpdatesMu.RUnlock()
+			return SchemaUpdateInit{}, fmt.Errorf("%w: station subscription doesn't exist", ErrSchemaNotLoaded)
+		}
+		sd := sus.schemaDetails
+		p.conn.stationUpdatesMu.RUnlock()
+		sui = SchemaUpdateInit{SchemaName: sd.name, SchemaType: sd.schemaType, ActiveVersion: sd.activeVersion}
+	}
+	return sui, nil
+}
+
+// ensureSchemaListener - subscribes to schema updates on first use for producers created with WithLazySchema.
+func (p *Producer) ensureSchemaListener() error {
+	p.schemaMu.Lock()
+	defer p.schemaMu.Unlock()
+
+	if p.schemaListening {
+		return nil
+	}
+	if err := p.conn.listenToConfirmedSchemaUpdates(p.stationName, &p.pendingSchemaInit); err != nil {
+		return memphisError(err)
+	}
+	p.schemaListening = true
+	return nil
+}
+
 // ProducerGenUniqueSuffix - whether to generate a unique suffix for this producer.
 func ProducerGenUniqueSuffix() ProducerOpt {
 	return func(opts *ProducerOpts) error {
@@ -478,6 +1573,206 @@ func ProducerGenUniqueSuffix() ProducerOpt {
 	}
 }
 
+// ProducerNameWithHostAndPid - append the hostname and process id to this producer's name instead of a random suffix,
+// the name is the same for every producer of the process with this name, but changes when the process restarts
+// since it gets a new pid.
+func ProducerNameWithHostAndPid() ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.HostAndPidSuffix = true
+		return nil
+	}
+}
+
+// ProducerGenUniqueSuffixN - whether to generate a unique suffix of numBytes random bytes for this producer, default length is 4 bytes.
+func ProducerGenUniqueSuffixN(numBytes int) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if numBytes <= 0 {
+			return errors.New("unique suffix length has to be a positive number")
+		}
+		opts.GenUniqueSuffix = true
+		opts.UniqueSuffixNumBytes = numBytes
+		return nil
+	}
+}
+
+// WithDefaultHeaders - headers added to every message produced by this producer,
+// headers passed with MsgHeaders on produce override them on key collision. The headers are copied,
+// changing hdrs afterwards doesn't affect the producer.
+func WithDefaultHeaders(hdrs Headers) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		for key := range hdrs.MsgHeaders {
+			if err := hdrs.validateHeaderKey(key); err != nil {
+				return err
+			}
+		}
+		opts.DefaultHeaders = Headers{MsgHeaders: copyHeaders(hdrs.MsgHeaders)}
+		return nil
+	}
+}
+
+// WithDefaultAckWaitSec - default max time in seconds to wait for an ack from memphis for this producer's messages, default is 15 seconds.
+// AckWaitSec passed on produce overrides it.
+func WithDefaultAckWaitSec(ackWaitSec int) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if ackWaitSec <= 0 {
+			return errors.New("default ack wait has to be a positive number")
+		}
+		opts.DefaultAckWaitSec = ackWaitSec
+		return nil
+	}
+}
+
+// WithDefaultEncoder - encoder for messages of types other than []byte/string/io.Reader produced to stations without a schema,
+// the connection's JSON marshaler is used when encoder is nil. Without this option such messages are rejected.
+func WithDefaultEncoder(encoder Encoder) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.DefaultEncoder = encoder
+		opts.jsonEncoder = encoder == nil
+		return nil
+	}
+}
+
+// WithMsgpackEncoding - encode messages produced to stations without a schema with msgpack, []byte/string/io.Reader
+// messages are passed as is. Messages are marked with a content type header so consumers can decode them with Msg.Decode.
+func WithMsgpackEncoding() ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.DefaultEncoder = msgpack.Marshal
+		opts.ContentType = msgpackContentType
+		return nil
+	}
+}
+
+// WithCircuitBreaker - after failureThreshold consecutive publish failures Produce fails fast with ErrCircuitOpen,
+// once cooldown elapses a single produce is let through as a probe and closes the breaker if it succeeds.
+// Only publish failures are counted, validation errors are not.
+func WithCircuitBreaker(failureThreshold int, cooldown time.Duration) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if failureThreshold <= 0 {
+			return errors.New("failure threshold has to be a positive number")
+		}
+		if cooldown <= 0 {
+			return errors.New("cooldown has to be a positive duration")
+		}
+		opts.CircuitBreakerFailures = failureThreshold
+		opts.CircuitBreakerCooldown = cooldown
+		return nil
+	}
+}
+
+// Producer.Subject - the subject messages are produced to, messages produced WithSubjectKey are produced to
+// the subject extended with their key.
+func (p *Producer) Subject() string {
+	return p.conn.produceSubject(p.stationName)
+}
+
+// Producer.InFlight - the number of async produces not acked by the broker yet, always 0 without WithMaxInflight.
+func (p *Producer) InFlight() int {
+	return len(p.inflight)
+}
+
+// acquireInflight - waits for an in-flight slot of an async produce, until ctx is done.
+func (p *Producer) acquireInflight(ctx context.Context) error {
+	if p.inflight == nil {
+		return nil
+	}
+	select {
+	case p.inflight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Producer) releaseInflight() {
+	if p.inflight != nil {
+		<-p.inflight
+	}
+}
+
+// Producer.CircuitState - get the state of the producer's circuit breaker, always closed without a circuit breaker.
+func (p *Producer) CircuitState() CircuitState {
+	if p.circuitBreaker == nil {
+		return CircuitClosed
+	}
+	return p.circuitBreaker.currentState()
+}
+
+// WithInterceptor - wrap the producer's produces with interceptor, interceptors run before the message is validated
+// and encoded so they can change the message and its options. Interceptors run in the order they are added,
+// the first one added is the outermost and the last one calls the actual produce.
+func WithInterceptor(interceptor ProduceInterceptor) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if interceptor == nil {
+			return errors.New("interceptor can't be nil")
+		}
+		opts.Interceptors = append(opts.Interceptors, interceptor)
+		return nil
+	}
+}
+
+// WithMaxInflight - block async produces once n of them aren't acked by the broker, until one is acked or fails,
+// to bound memory use while the broker is slow. A blocked produce returns when its context (WithContext/WithTimeout) is done.
+// An unacked produce stops counting once its AckWaitSec elapses.
+func WithMaxInflight(n int) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if n <= 0 {
+			return errors.New("max inflight has to be a positive number")
+		}
+		opts.MaxInflight = n
+		return nil
+	}
+}
+
+// WithEagerSchema - compile the station's schema on producer creation instead of on first produce,
+// schema compilation errors are returned by the producer creation.
+func WithEagerSchema() ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.EagerSchema = true
+		opts.LazySchema = false
+		return nil
+	}
+}
+
+// WithLazySchema - subscribe to the station's schema updates on first produce instead of on producer creation,
+// until then the schema received on creation is used.
+func WithLazySchema() ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.LazySchema = true
+		opts.EagerSchema = false
+		return nil
+	}
+}
+
+// WithRateLimit - limit this producer to perSecond produces per second with bursts of up to burst produces,
+// the limit is per producer. Produce blocks until allowed, or until the context passed with WithContext is done.
+func WithRateLimit(perSecond int, burst int) ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		if perSecond <= 0 || burst <= 0 {
+			return errors.New("rate limit and burst have to be positive numbers")
+		}
+		opts.RateLimitPerSecond = perSecond
+		opts.RateLimitBurst = burst
+		return nil
+	}
+}
+
+// WithRateLimitFailFast - return ErrRateLimited when the rate limit is exceeded instead of blocking.
+func WithRateLimitFailFast() ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.RateLimitFailFast = true
+		return nil
+	}
+}
+
+// WithValidationDisabled - skip client side schema validation for all messages of this producer,
+// messages are sent as raw bytes and may still be rejected by the broker.
+func WithValidationDisabled() ProducerOpt {
+	return func(opts *ProducerOpts) error {
+		opts.ValidationDisabled = true
+		return nil
+	}
+}
+
 // AckWaitSec - max time in seconds to wait for an ack from memphis.
 func AckWaitSec(ackWaitSec int) ProduceOpt {
 	return func(opts *ProduceOpts) error {
@@ -502,6 +1797,251 @@ func AsyncProduce() ProduceOpt {
 	}
 }
 
+// WithRawHeaders - NATS headers merged into the message as is, for interop with non memphis NATS consumers.
+// Precedence from lowest to highest: producer default headers, MsgHeaders, raw headers, memphis headers.
+// Keys starting with $memphis are rejected.
+func WithRawHeaders(headers nats.Header) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		for key := range headers {
+			if strings.HasPrefix(key, "$memphis") {
+				return fmt.Errorf("raw header %q: keys in headers should not start with $memphis", key)
+			}
+		}
+		opts.RawHeaders = headers
+		return nil
+	}
+}
+
+// WithBinaryHeader - attach binary metadata (e.g. a checksum) to the message, read by consumers with Msg.BinaryHeader.
+// On the wire the value is sent in the $memphis_bin_<key> header, encoded with standard base64 (RFC 4648, padded).
+// Binary headers count towards the headers size limit like string headers.
+func WithBinaryHeader(key string, value []byte) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if key == "" {
+			return errors.New("binary header key can not be empty")
+		}
+		if strings.HasPrefix(key, "$memphis") {
+			return fmt.Errorf("binary header %q: keys in headers should not start with $memphis", key)
+		}
+		if opts.BinaryHeaders == nil {
+			opts.BinaryHeaders = map[string][]byte{}
+		}
+		opts.BinaryHeaders[key] = value
+		return nil
+	}
+}
+
+// WithContentType - set the message's content-type header, by default messages encoded by the client are tagged
+// with the content type of their encoding (json/protobuf/graphql/msgpack).
+func WithContentType(contentType string) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if contentType == "" {
+			return errors.New("content type can not be empty")
+		}
+		opts.ContentType = contentType
+		return nil
+	}
+}
+
+// WithSchema - validate this message against the given schema instead of the station's schema, for stations holding
+// messages of several shapes (e.g. one per tenant). The station's cached schema is not changed. The schema is compiled
+// once per call to WithSchema, so reuse the returned option when producing many messages against the same schema.
+// Messages failing the schema aren't sent to the station's DLS, and messages validated against it aren't stamped
+// with the station's schema version header.
+func WithSchema(sui SchemaUpdateInit) ProduceOpt {
+	sd := schemaDetails{}
+	sd.setSchemaUpdateInit(sui)
+	var err error
+	if !isSupportedSchemaType(sui.SchemaType) {
+		err = ErrUnsupportedSchemaType{Type: sui.SchemaType}
+	} else if err = sd.compile(); err != nil {
+		err = fmt.Errorf("schema %v version %v failed to compile: %w", sui.SchemaName, sui.ActiveVersion.VersionNumber, err)
+	}
+
+	return func(opts *ProduceOpts) error {
+		if err != nil {
+			return memphisError(err)
+		}
+		opts.schema = &sd
+		return nil
+	}
+}
+
+// WithSubjectKey - produce the message to a subject extended with key as an additional token, e.g. station.final.<key>,
+// for per key ordering and compaction. The key has to be a single subject token without wildcards. Keyed messages
+// are stored only if the station's stream captures the extended subject, and consumers of the station don't
+// receive them unless they subscribe to the extended subject.
+func WithSubjectKey(key string) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if err := validateSubjectToken(key); err != nil {
+			return fmt.Errorf("invalid subject key: %w", err)
+		}
+		opts.SubjectKey = key
+		return nil
+	}
+}
+
+func subjectKeySuffix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return "." + key
+}
+
+// WithPreEncoded - trust a []byte message produced to a protobuf station as already encoded with the station's schema,
+// skipping its client side validation. The max message size is still enforced. Malformed bytes are not detected
+// by the client and may break consumers or be rejected by the broker.
+func WithPreEncoded() ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		opts.PreEncoded = true
+		return nil
+	}
+}
+
+// SkipValidation - skip client side schema validation for this message, it is sent as raw bytes
+// and may still be rejected by the broker.
+func SkipValidation() ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		opts.SkipValidation = true
+		return nil
+	}
+}
+
+// WithMessageStruct - the protobuf message struct of the station's schema to validate the message against,
+// by default the struct is matched by the message's type or the schema's default struct is used.
+func WithMessageStruct(name string) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		opts.MessageStructName = name
+		return nil
+	}
+}
+
+// WithTTL - time to live of the message, consumers skip and ack messages whose TTL elapsed since they were stored.
+// The TTL is enforced by consumers, not by the broker. Dead letter messages carry no stored time, so they never expire.
+func WithTTL(ttl time.Duration) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if ttl < time.Millisecond {
+			return errors.New("ttl has to be at least 1 millisecond")
+		}
+		opts.TTL = ttl
+		return nil
+	}
+}
+
+// WithDeliverAfter - delay handing the message to consumers by d. Consumers that fetch the message earlier nack it
+// to be redelivered once the delay elapses, so delivery is at least once and each early fetch counts as a delivery.
+func WithDeliverAfter(d time.Duration) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if d < time.Millisecond {
+			return errors.New("deliver after has to be at least 1 millisecond")
+		}
+		opts.DeliverAfter = d
+		return nil
+	}
+}
+
+// WithEventTime - the time the event the message describes happened, e.g. when backfilling historical data.
+// It's passed in a header and read by consumers with Msg.EventTime, the time the broker stored the message isn't affected
+// and TTL and delivery delays still count from when the message is produced. Millisecond precision.
+func WithEventTime(t time.Time) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if t.IsZero() {
+			return errors.New("event time can't be zero")
+		}
+		opts.EventTime = t
+		return nil
+	}
+}
+
+// WithRetry - retry publishing on transient failures (timeouts, no responders) up to maxAttempts attempts,
+// schema validation failures are never retried.
+func WithRetry(maxAttempts int, backoff BackoffStrategy) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if maxAttempts <= 0 {
+			return errors.New("max attempts has to be a positive number")
+		}
+		opts.MaxAttempts = maxAttempts
+		opts.Backoff = backoff
+		return nil
+	}
+}
+
+// Producer.Request - produces message and waits up to timeout for a single reply, sent by the consumer with Msg.Respond.
+// The reply is received on replyStation, or on a temporary inbox when replyStation is empty, other messages
+// of replyStation are ignored. Replies aren't consumed through a consumer group, so they don't have to be acked.
+func (p *Producer) Request(message any, replyStation string, timeout time.Duration) (*Msg, error) {
+	replyTo := p.conn.brokerConn.NewInbox()
+	if replyStation != "" {
+		replyTo = p.conn.produceSubject(replyStation)
+	}
+	correlationID, err := randomHex(16)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+
+	sub, err := p.conn.brokerConn.SubscribeSync(replyTo)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	defer sub.Unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err = p.Produce(message, WithContext(ctx), func(opts *ProduceOpts) error {
+		opts.replyTo, opts.correlationID = replyTo, correlationID
+		return nil
+	})
+	if err != nil {
+		return nil, memphisError(err)
+	}
+
+	for {
+		reply, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, memphisError(fmt.Errorf("%w after %v", ErrRequestTimeout, timeout))
+			}
+			return nil, memphisError(err)
+		}
+		if reply.Header.Get(correlationIdHeader) == correlationID {
+			return &Msg{msg: reply, conn: p.conn, station: replyStation}, nil
+		}
+	}
+}
+
+// WithContext - a context bounding the produce operation, publishing doesn't stall past the context's deadline even if AckWaitSec is longer.
+func WithContext(ctx context.Context) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		opts.Context = ctx
+		return nil
+	}
+}
+
+// OnLatency - report the publish to ack duration of the produce to fn, a lightweight alternative to metrics for ad hoc profiling.
+// Best-effort: fn is called after the broker's ack, from another goroutine for async produces, and isn't called for failed produces.
+// With retries only the successful attempt is measured.
+func OnLatency(fn func(d time.Duration)) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if fn == nil {
+			return errors.New("latency callback can't be nil")
+		}
+		opts.onLatency = fn
+		return nil
+	}
+}
+
+// WithTimeout - bounds the total time of the produce call, including retries and waiting for the broker's ack,
+// ErrProduceTimeout is returned when it elapses. Publishing may stall for AckWaitSec at most, or less if the timeout elapses first.
+func WithTimeout(timeout time.Duration) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if timeout <= 0 {
+			return errors.New("timeout has to be a positive duration")
+		}
+		opts.Timeout = timeout
+		return nil
+	}
+}
+
 // MsgId - set an id for a message for idempotent producer
 func MsgId(id string) ProduceOpt {
 	return func(opts *ProduceOpts) error {
@@ -509,3 +2049,24 @@ func MsgId(id string) ProduceOpt {
 		return nil
 	}
 }
+
+// WithIdempotencyScope - deduplicate the message's id only against messages produced with the same scope,
+// the station deduplicates by "<scope>:<id>". Has no effect on messages produced without a message id.
+func WithIdempotencyScope(scope string) ProduceOpt {
+	return func(opts *ProduceOpts) error {
+		if scope == "" {
+			return errors.New("idempotency scope can't be empty")
+		}
+		opts.IdempotencyScope = scope
+		return nil
+	}
+}
+
+// scopeMsgIds - prefixes the message's ids with the idempotency scope.
+func scopeMsgIds(headers map[string][]string, scope string) {
+	for _, key := range []string{"msg-id", nats.MsgIdHdr} {
+		if ids, ok := headers[key]; ok && len(ids) > 0 {
+			headers[key] = []string{scope + ":" + ids[0]}
+		}
+	}
+}
@@ -1,9 +1,26 @@
 package memphis
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/nats-io/nats.go"
 )
 
 func TestCreateProducer(t *testing.T) {
@@ -368,3 +385,1467 @@ func TestFullFlow(t *testing.T) {
 		t.Errorf("Consumer destruction failed: %v\n", err)
 	}
 }
+
+func TestExtendNameWithRandSuffix(t *testing.T) {
+	name, err := extendNameWithRandSuffix("producer_name_a", 4)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(name) != len("producer_name_a_")+8 {
+		t.Errorf("unexpected suffix length: %v", name)
+	}
+
+	name, err = extendNameWithRandSuffix("producer_name_a", 2)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(name) != len("producer_name_a_")+4 {
+		t.Errorf("unexpected suffix length: %v", name)
+	}
+
+	opts := getDefaultProducerOpts()
+	if err := ProducerGenUniqueSuffixN(0)(&opts); err == nil {
+		t.Error("suffix length has to be positive")
+	}
+}
+
+func TestBuildMsgHeaders(t *testing.T) {
+	p := Producer{
+		Name:           "producer_name_a",
+		conn:           &Conn{ConnId: "conn_id"},
+		defaultHeaders: map[string][]string{"tenant": {"a"}, "source": {"svc"}},
+	}
+
+	headers := p.buildMsgHeaders(map[string][]string{"tenant": {"b"}}, nil)
+	if headers["tenant"][0] != "b" {
+		t.Error("message headers should override default headers")
+	}
+	if headers["source"][0] != "svc" {
+		t.Error("default headers are missing")
+	}
+	if headers["$memphis_producedBy"][0] != "producer_name_a" || headers["$memphis_connectionId"][0] != "conn_id" {
+		t.Error("memphis headers are missing")
+	}
+	if len(p.defaultHeaders["tenant"]) != 1 || p.defaultHeaders["tenant"][0] != "a" {
+		t.Error("default headers should not be modified")
+	}
+}
+
+func TestDefaultHeadersCopied(t *testing.T) {
+	hdrs := Headers{MsgHeaders: map[string][]string{"tenant": {"a"}}}
+	opts := getDefaultProducerOpts()
+	if err := WithDefaultHeaders(hdrs)(&opts); err != nil {
+		t.Fatal(err)
+	}
+	hdrs.MsgHeaders["tenant"][0] = "b"
+	hdrs.MsgHeaders["source"] = []string{"svc"}
+	if opts.DefaultHeaders.MsgHeaders["tenant"][0] != "a" {
+		t.Error("changing the caller's headers should not affect the default headers")
+	}
+	if _, ok := opts.DefaultHeaders.MsgHeaders["source"]; ok {
+		t.Error("keys added to the caller's headers should not show up in the default headers")
+	}
+}
+
+func TestRawHeaders(t *testing.T) {
+	p := Producer{Name: "producer_name_a", conn: &Conn{ConnId: "conn_id"}, defaultHeaders: map[string][]string{"source": {"svc"}}}
+
+	opts := ProduceOpts{}
+	if err := WithRawHeaders(nats.Header{"Nats-Msg-Id": {"1"}, "tenant": {"raw"}})(&opts); err != nil {
+		t.Fatal(err)
+	}
+	headers := p.buildMsgHeaders(map[string][]string{"tenant": {"b"}}, opts.RawHeaders)
+	if headers["tenant"][0] != "raw" || headers["Nats-Msg-Id"][0] != "1" {
+		t.Error("raw headers should override message headers")
+	}
+	if headers["source"][0] != "svc" || headers["$memphis_producedBy"][0] != "producer_name_a" {
+		t.Error("default and memphis headers are missing")
+	}
+
+	if err := WithRawHeaders(nats.Header{"$memphis_producedBy": {"x"}})(&opts); err == nil {
+		t.Error("expected error for a raw $memphis header")
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	hdrs := Headers{}
+	hdrs.New()
+
+	if err := hdrs.Add("key", "a"); err != nil {
+		t.Error(err)
+	}
+	if err := hdrs.Add("key", "b"); err != nil {
+		t.Error(err)
+	}
+	if values, ok := hdrs.Get("key"); !ok || len(values) != 2 {
+		t.Errorf("expected 2 values, got %v", values)
+	}
+
+	if err := hdrs.Set("key", "c"); err != nil {
+		t.Error(err)
+	}
+	if values, _ := hdrs.Get("key"); len(values) != 1 || values[0] != "c" {
+		t.Errorf("expected single value, got %v", values)
+	}
+
+	if err := hdrs.Remove("key"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := hdrs.Get("key"); ok {
+		t.Error("key should be removed")
+	}
+
+	if err := hdrs.Set("$memphis_key", "value"); err == nil {
+		t.Error("keys starting with $memphis should be rejected")
+	}
+}
+
+func TestValidateHeaders(t *testing.T) {
+	c := &Conn{opts: getDefaultOptions()}
+	c.opts.MaxHeadersCount = 2
+	c.opts.MaxHeadersSize = 10
+
+	if err := c.validateHeaders(map[string][]string{"a": {"b"}, "c": {"d"}}); err != nil {
+		t.Error(err)
+	}
+	if err := c.validateHeaders(map[string][]string{"a": {"b", "c", "d"}}); !errors.Is(err, ErrTooManyHeaders) {
+		t.Errorf("expected ErrTooManyHeaders, got %v", err)
+	}
+	if err := c.validateHeaders(map[string][]string{"key": {"long value"}}); !errors.Is(err, ErrHeadersTooLarge) {
+		t.Errorf("expected ErrHeadersTooLarge, got %v", err)
+	}
+}
+
+func TestValidateMsgSize(t *testing.T) {
+	c := &Conn{maxPayload: 4}
+	if err := c.validateMsgSize([]byte("abcd")); err != nil {
+		t.Error(err)
+	}
+	if err := c.validateMsgSize([]byte("abcde")); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(100*time.Millisecond, time.Second)
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, wait := range expected {
+		if backoff(i+1) != wait {
+			t.Errorf("attempt %v: expected %v, got %v", i+1, wait, backoff(i+1))
+		}
+	}
+}
+
+func TestIsTransientProduceErr(t *testing.T) {
+	if !isTransientProduceErr(memphisError(nats.ErrTimeout)) {
+		t.Error("timeout should be transient")
+	}
+	if !isTransientProduceErr(memphisError(nats.ErrNoResponders)) {
+		t.Error("no responders should be transient")
+	}
+	if isTransientProduceErr(errors.New("Schema validation has failed: bad message")) {
+		t.Error("schema validation failure should not be transient")
+	}
+}
+
+func TestReadMsg(t *testing.T) {
+	c := &Conn{maxPayload: 4}
+	data, err := c.readMsg(strings.NewReader("abcd"))
+	if err != nil || string(data) != "abcd" {
+		t.Errorf("unexpected read result: %v, %v", string(data), err)
+	}
+
+	_, err = c.readMsg(strings.NewReader("abcde"))
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(10, 2)
+	if !rl.allow() || !rl.allow() {
+		t.Error("burst should be allowed")
+	}
+	if rl.allow() {
+		t.Error("rate limit should be exceeded")
+	}
+
+	start := time.Now()
+	if err := rl.wait(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("wait should block until a token is available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rl.wait(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestProduceContextErr(t *testing.T) {
+	opts := getDefaultProduceOpts()
+	opts.Timeout = time.Second
+	if err := opts.contextErr(context.DeadlineExceeded); !errors.Is(err, ErrProduceTimeout) {
+		t.Errorf("expected ErrProduceTimeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts.Context = ctx
+	if err := opts.contextErr(context.Canceled); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func newTestJsMsg(storedAt time.Time, headers nats.Header) *nats.Msg {
+	return &nats.Msg{
+		Sub:    &nats.Subscription{},
+		Reply:  fmt.Sprintf("$JS.ACK.station.consumer.1.5.5.%v.0", storedAt.UnixNano()),
+		Header: headers,
+	}
+}
+
+func TestMsgEventTime(t *testing.T) {
+	opts := ProduceOpts{}
+	if err := WithEventTime(time.Time{})(&opts); err == nil {
+		t.Error("expected error for a zero event time")
+	}
+	eventTime := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	p, pubs := newTestProducer(t)
+	if err := p.Produce("event", WithEventTime(eventTime), WithTTL(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	pub := <-pubs
+	msg := Msg{msg: newTestJsMsg(time.Now(), pub.header)}
+	if got, ok := msg.EventTime(); !ok || !got.Equal(eventTime) {
+		t.Errorf("unexpected event time %v", got)
+	}
+	if msg.isExpired(time.Now()) {
+		t.Error("the TTL should be relative to the produce time, not the event time")
+	}
+
+	msg = Msg{msg: newTestJsMsg(time.Now(), nats.Header{})}
+	if _, ok := msg.EventTime(); ok {
+		t.Error("message without event time should not have one")
+	}
+}
+
+func TestNewHeaders(t *testing.T) {
+	hdr, err := NewHeaders(map[string]string{"a": "1", "b": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := hdr.Get("b"); len(v) != 1 || v[0] != "2" {
+		t.Errorf("unexpected values %v", v)
+	}
+
+	hdr, err = NewMultiHeaders(map[string][]string{"a": {"1", "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := hdr.Get("a"); len(v) != 2 {
+		t.Errorf("unexpected values %v", v)
+	}
+
+	_, err = NewHeaders(map[string]string{"a": "1", "$memphis_b": "2", "$memphis_c": "3"})
+	if err == nil || !strings.Contains(err.Error(), "$memphis_b") {
+		t.Errorf("expected error reporting $memphis_b, got %v", err)
+	}
+}
+
+func TestProduceWithDefaultEncoder(t *testing.T) {
+	pubs := make(chan testPublish, 10)
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		if pub.subject == "$memphis_producer_creations" {
+			return testReply(`{}`)
+		}
+		pubs <- pub
+		return testPubAck(nil)
+	})
+	type event struct {
+		Id int `json:"id"`
+	}
+
+	p, err := c.CreateProducer("station_name", "producer_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Produce(event{Id: 1}); !errors.Is(err, ErrUnsupportedMsgType) {
+		t.Errorf("expected ErrUnsupportedMsgType without an encoder, got %v", err)
+	}
+
+	p, err = c.CreateProducer("station_name", "producer_b", WithDefaultEncoder(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Produce(event{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	pub := <-pubs
+	if string(pub.data) != `{"id":1}` || pub.header.Get(contentTypeKey) != "application/json" {
+		t.Errorf("expected a JSON message, got %q with headers %v", pub.data, pub.header)
+	}
+
+	custom := func(msg any) ([]byte, error) {
+		return []byte(fmt.Sprintf("%+v", msg)), nil
+	}
+	p, err = c.CreateProducer("station_name", "producer_c", WithDefaultEncoder(custom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Produce(event{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Produce("raw"); err != nil {
+		t.Fatal(err)
+	}
+	if pub := <-pubs; string(pub.data) != "{Id:1}" {
+		t.Errorf("expected the custom encoder to be used, got %q", pub.data)
+	}
+	if pub := <-pubs; string(pub.data) != "raw" {
+		t.Errorf("strings should be produced as is, got %q", pub.data)
+	}
+}
+
+func TestExtendNameWithHostAndPid(t *testing.T) {
+	name := extendNameWithHostAndPid("producer_name_a")
+	if !strings.HasPrefix(name, "producer_name_a_") || !strings.HasSuffix(name, "_"+strconv.Itoa(os.Getpid())) {
+		t.Errorf("unexpected name %v", name)
+	}
+	if name != extendNameWithHostAndPid("producer_name_a") {
+		t.Error("name should be stable within the process")
+	}
+	if err := validateName(name, "producer"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testPubAckFuture struct {
+	ok  chan *nats.PubAck
+	err chan error
+}
+
+func newTestPubAckFuture() *testPubAckFuture {
+	return &testPubAckFuture{ok: make(chan *nats.PubAck, 1), err: make(chan error, 1)}
+}
+
+func (f *testPubAckFuture) Ok() <-chan *nats.PubAck { return f.ok }
+func (f *testPubAckFuture) Err() <-chan error       { return f.err }
+func (f *testPubAckFuture) Msg() *nats.Msg          { return nil }
+
+// testPublish - a message published to the test broker.
+type testPublish struct {
+	subject string
+	header  nats.Header
+	data    []byte
+}
+
+// newTestJetStreamConn - a connection to a minimal NATS server passing every message published to it to respond,
+// the messages respond returns are delivered to their subject if set, otherwise to the published message's reply subject.
+func newTestJetStreamConn(t *testing.T, respond func(testPublish) []*nats.Msg) *Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestJetStream(conn, respond)
+		}
+	}()
+
+	nc, err := nats.Connect("nats://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Conn{
+		ConnId:             "conn_id",
+		brokerConn:         nc,
+		js:                 js,
+		opts:               getDefaultOptions(),
+		producersMap:       make(ProducersMap),
+		producers:          make(map[*Producer]struct{}),
+		consumers:          make(map[*Consumer]struct{}),
+		stationUpdatesSubs: make(map[string]*stationUpdateSub),
+		configUpdatesSub: configurationsUpdateSub{
+			ClusterConfigurations:      make(map[string]bool),
+			StationSchemaverseToDlsMap: make(map[string]bool),
+		},
+	}
+}
+
+func serveTestJetStream(conn net.Conn, respond func(testPublish) []*nats.Msg) {
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.9.0\",\"headers\":true,\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	subs := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "SUB":
+			subs[strings.TrimSuffix(fields[1], "*")] = fields[len(fields)-1]
+		case "PUB", "HPUB":
+			// PUB <subject> [reply] <size>, HPUB <subject> [reply] <header size> <size>
+			args := fields[1 : len(fields)-1]
+			hdrLen := 0
+			if fields[0] == "HPUB" {
+				hdrLen, _ = strconv.Atoi(args[len(args)-1])
+				args = args[:len(args)-1]
+			}
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			replies := respond(testPublish{subject: args[0], header: parseTestHeader(buf[:hdrLen]), data: buf[hdrLen:size]})
+			for _, msg := range replies {
+				subject := msg.Subject
+				if subject == "" && len(args) > 1 {
+					subject = args[1]
+				}
+				for prefix, sid := range subs {
+					if subject != "" && strings.HasPrefix(subject, prefix) {
+						writeTestMsg(conn, subject, sid, msg)
+					}
+				}
+			}
+		}
+	}
+}
+
+// writeTestMsg - delivers msg to the subscription sid on subject, with msg's reply subject and headers.
+func writeTestMsg(w io.Writer, subject, sid string, msg *nats.Msg) {
+	subject = strings.TrimSpace(subject + " " + sid + " " + msg.Reply)
+	if len(msg.Header) == 0 {
+		fmt.Fprintf(w, "MSG %v %v\r\n%s\r\n", subject, len(msg.Data), msg.Data)
+		return
+	}
+	var hdr strings.Builder
+	hdr.WriteString("NATS/1.0\r\n")
+	for k, values := range msg.Header {
+		for _, v := range values {
+			fmt.Fprintf(&hdr, "%v: %v\r\n", k, v)
+		}
+	}
+	hdr.WriteString("\r\n")
+	fmt.Fprintf(w, "HMSG %v %v %v\r\n%v%s\r\n", subject, hdr.Len(), hdr.Len()+len(msg.Data), hdr.String(), msg.Data)
+}
+
+// testReply - a reply with data.
+func testReply(data string) []*nats.Msg {
+	return []*nats.Msg{{Data: []byte(data)}}
+}
+
+// testPubAck - the broker's reply to a published message, an ack or an error if err isn't nil.
+func testPubAck(err error) []*nats.Msg {
+	if err != nil {
+		return testReply(fmt.Sprintf(`{"error":{"code":503,"description":%q}}`, err.Error()))
+	}
+	return testReply(`{"stream":"test","seq":1}`)
+}
+
+// newTestProducer - a producer of station_name on a test broker, the messages it publishes are sent to the returned channel.
+func newTestProducer(t *testing.T) (*Producer, <-chan testPublish) {
+	pubs := make(chan testPublish, 10)
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		pubs <- pub
+		return testPubAck(nil)
+	})
+	return &Producer{Name: "producer_name", realName: "producer_name", stationName: "station_name", conn: c}, pubs
+}
+
+// parseTestHeader - parses a NATS/1.0 header block, keys are kept as is.
+func parseTestHeader(data []byte) nats.Header {
+	header := nats.Header{}
+	lines := strings.Split(string(data), "\r\n")
+	for _, line := range lines[1:] {
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			header[k] = append(header[k], strings.TrimSpace(v))
+		}
+	}
+	return header
+}
+
+func TestProduceWithValidationDisabled(t *testing.T) {
+	pubs := make(chan testPublish, 10)
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		if pub.subject == "$memphis_producer_creations" {
+			return testReply(`{}`)
+		}
+		pubs <- pub
+		return testPubAck(nil)
+	})
+	if err := c.InjectSchema("station_name", SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 1, Content: `{"type": "object", "required": ["id"]}`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	validating, err := c.CreateProducer("station_name", "producer_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validating.Produce([]byte(`{"name": "a"}`)); !errors.Is(err, ErrSchemaValidation) {
+		t.Fatalf("expected ErrSchemaValidation, got %v", err)
+	}
+
+	p, err := c.CreateProducer("station_name", "producer_b", WithValidationDisabled())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Produce([]byte(`{"name": "a"}`)); err != nil {
+		t.Fatalf("validation should be skipped, got %v", err)
+	}
+	pub := <-pubs
+	if pub.subject != "station_name.final" || string(pub.data) != `{"name": "a"}` {
+		t.Errorf("expected the raw message to be produced to the station, got %q to %v", pub.data, pub.subject)
+	}
+	if _, ok := pub.header[schemaVersionHeader]; ok {
+		t.Error("messages that weren't validated shouldn't be stamped with a schema version")
+	}
+}
+
+func TestProduceWithSchemaSkipsDlsAndVersion(t *testing.T) {
+	pubs := make(chan testPublish, 10)
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		pubs <- pub
+		return testPubAck(nil)
+	})
+	c.configUpdatesSub.StationSchemaverseToDlsMap = map[string]bool{"station_name": true}
+	if err := c.InjectSchema("station_name", SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 3, Content: `{"type": "object", "required": ["id"]}`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p := &Producer{Name: "producer_name", stationName: "station_name", conn: c}
+	tenantSchema := WithSchema(SchemaUpdateInit{
+		SchemaName:    "tenant_a",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 1, Content: `{"type": "object", "required": ["tenant"]}`},
+	})
+
+	if err := p.Produce(map[string]interface{}{"id": 1}, tenantSchema); !errors.Is(err, ErrSchemaValidation) {
+		t.Fatalf("expected ErrSchemaValidation, got %v", err)
+	}
+	if err := p.Produce(map[string]interface{}{"tenant": "a"}, tenantSchema); err != nil {
+		t.Fatal(err)
+	}
+	if pub := <-pubs; pub.subject != "station_name.final" {
+		t.Errorf("a failed per message validation shouldn't be sent to the DLS, got a publish to %v", pub.subject)
+	} else if _, ok := pub.header[schemaVersionHeader]; ok {
+		t.Error("messages validated against a per message schema shouldn't be stamped with a station schema version")
+	}
+
+	// the station's schema failures are still sent to the DLS
+	if err := p.Produce(map[string]interface{}{"tenant": "a"}); !errors.Is(err, ErrSchemaValidation) {
+		t.Fatalf("expected ErrSchemaValidation, got %v", err)
+	}
+	if pub := <-pubs; !strings.HasPrefix(pub.subject, "$memphis-station_name-dls.schema.") {
+		t.Errorf("expected the message to be sent to the DLS, got a publish to %v", pub.subject)
+	}
+}
+
+func TestProduceFanOut(t *testing.T) {
+	var mu sync.Mutex
+	var subjects []string
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		mu.Lock()
+		defer mu.Unlock()
+		subjects = append(subjects, pub.subject)
+		if strings.HasPrefix(pub.subject, "station_c.") {
+			return testPubAck(errors.New("storage full"))
+		}
+		return testPubAck(nil)
+	})
+	name := fanOutProducerName(c.ConnId)
+	open := newCircuitBreaker(1, time.Hour)
+	open.done(true, errors.New("publish failed"))
+	for _, p := range []*Producer{
+		{Name: name, realName: name, stationName: "station_a", conn: c},
+		{Name: name, realName: name, stationName: "station_b", conn: c, circuitBreaker: open},
+		{Name: name, realName: name, stationName: "station_c", conn: c},
+	} {
+		c.cacheProducer(p)
+	}
+
+	stations := []string{"station_a", "station b", "station_b", "station_c"}
+	for i := 0; i < 2; i++ {
+		errs := c.ProduceFanOut(stations, []byte("msg"))
+		if len(errs) != len(stations) {
+			t.Fatalf("expected an error slot per station, got %v", len(errs))
+		}
+		if errs[0] != nil {
+			t.Errorf("expected the message to be produced to station_a, got %v", errs[0])
+		}
+		if errs[1] == nil {
+			t.Error("expected an error for an invalid station name")
+		}
+		if !errors.Is(errs[2], ErrCircuitOpen) {
+			t.Errorf("expected ErrCircuitOpen for station_b, got %v", errs[2])
+		}
+		if errs[3] == nil || !strings.Contains(errs[3].Error(), "storage full") {
+			t.Errorf("expected the broker's error for station_c, got %v", errs[3])
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(subjects)
+	if strings.Join(subjects, ",") != "station_a.final,station_a.final,station_c.final,station_c.final" {
+		t.Errorf("the cached fan-out producers should be reused without creating producers, got publishes to %v", subjects)
+	}
+	if len(c.getProducersMap()) != 3 {
+		t.Errorf("no producers should be added to the cache, got %v", len(c.getProducersMap()))
+	}
+}
+
+func TestProducerDestroy(t *testing.T) {
+	brokerErr := ""
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		return testReply(brokerErr)
+	})
+	newProducer := func(schemaListening bool) *Producer {
+		p := &Producer{Name: "producer_name", realName: "producer_name", stationName: "station_name", conn: c, schemaListening: schemaListening}
+		c.trackProducer(p)
+		return p
+	}
+
+	p := newProducer(false)
+	if err := p.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.producers) != 0 {
+		t.Error("a destroyed producer should be removed from the connection")
+	}
+
+	brokerErr = "producer is in use"
+	p = newProducer(false)
+	if err := p.Destroy(); err == nil || !strings.Contains(err.Error(), brokerErr) {
+		t.Errorf("expected the broker's error, got %v", err)
+	}
+	if len(c.producers) != 1 {
+		t.Error("a producer the broker failed to destroy should be kept")
+	}
+	c.untrackProducer(p)
+
+	// no schema updates listener was added, so removing it fails
+	brokerErr = ""
+	p = newProducer(true)
+	err := p.Destroy()
+	if err == nil {
+		t.Fatal("expected the listener removal error")
+	}
+	if len(c.producers) != 0 || p.schemaListening {
+		t.Error("a producer destroyed by the broker should be removed even if its listener removal failed")
+	}
+	listenerErr := err.Error()
+
+	brokerErr = "producer is in use"
+	p = newProducer(true)
+	err = p.Destroy()
+	var joined *multiError
+	if !errors.As(err, &joined) || len(joined.errs) != 2 {
+		t.Fatalf("expected the listener removal and broker errors joined, got %v", err)
+	}
+	if !strings.Contains(err.Error(), listenerErr) || !strings.Contains(err.Error(), brokerErr) {
+		t.Errorf("expected both errors, got %v", err)
+	}
+}
+
+func TestDestroyAll(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	var inUse int32 = 1
+	var mu sync.Mutex
+	destroyed := map[string]int{}
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		switch {
+		case strings.Contains(string(pub.data), "producer_slow"):
+			<-block
+		case strings.Contains(string(pub.data), "producer_in_use") && atomic.LoadInt32(&inUse) == 1:
+			return testReply("producer_in_use is in use")
+		case pub.subject == "$memphis_producer_destructions":
+			var req removeProducerReq
+			json.Unmarshal(pub.data, &req)
+			mu.Lock()
+			destroyed[req.Name]++
+			mu.Unlock()
+			return testReply("")
+		case pub.subject == "$memphis_consumer_destructions":
+			return testReply("")
+		}
+		return testConsumerInfo(pub)
+	})
+	for _, name := range []string{"producer_a", "producer_in_use"} {
+		p := &Producer{Name: name, realName: name, stationName: "station_name", conn: c}
+		c.trackProducer(p)
+		c.cacheProducer(p)
+	}
+	consumer := newTestPullConsumer(t, c)
+	c.trackConsumer(consumer)
+
+	err := c.DestroyAll(5 * time.Second)
+	if err == nil || !strings.Contains(err.Error(), "producer_in_use is in use") {
+		t.Errorf("expected the failed destroy's error, got %v", err)
+	}
+	if len(c.producers) != 1 || len(c.consumers) != 0 {
+		t.Errorf("expected only the producer that failed to be kept, got %v producers and %v consumers", len(c.producers), len(c.consumers))
+	}
+	if _, err := c.getProducerFromCache("station_name", "producer_a"); err == nil {
+		t.Error("a destroyed producer should be removed from the cache")
+	}
+	if len(consumer.pingQuit) != 1 {
+		t.Error("the destroyed consumer's pings should be stopped")
+	}
+
+	atomic.StoreInt32(&inUse, 0)
+	if err := c.DestroyAll(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.producers) != 0 || len(c.getProducersMap()) != 0 {
+		t.Errorf("expected no producers left, got %v tracked and %v cached", len(c.producers), len(c.getProducersMap()))
+	}
+	mu.Lock()
+	if destroyed["producer_a"] != 1 || destroyed["producer_in_use"] != 1 {
+		t.Errorf("each producer should be destroyed once, got %v", destroyed)
+	}
+	mu.Unlock()
+
+	c.trackProducer(&Producer{Name: "producer_slow", realName: "producer_slow", stationName: "station_name", conn: c})
+	if err := c.DestroyAll(100 * time.Millisecond); err == nil || !strings.Contains(err.Error(), "destroy timed out") {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
+
+func TestProduceGroupWait(t *testing.T) {
+	g := (&Producer{}).NewProduceGroup()
+	acked, failed := newTestPubAckFuture(), newTestPubAckFuture()
+	g.track(acked)
+	g.track(failed)
+	acked.ok <- &nats.PubAck{}
+	failed.err <- errors.New("publish failed")
+
+	err := g.Wait(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "publish failed") {
+		t.Errorf("expected the publish failure, got %v", err)
+	}
+	if err := g.Wait(context.Background()); err != nil {
+		t.Errorf("the group should be reset after Wait, got %v", err)
+	}
+
+	g.track(newTestPubAckFuture())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRawMsgBytesUnsupportedType(t *testing.T) {
+	type event struct{ Id int }
+	p := Producer{stationName: "station_name_a", conn: &Conn{}}
+
+	_, err := p.rawMsgBytes(event{Id: 1})
+	if !errors.Is(err, ErrUnsupportedMsgType) {
+		t.Fatalf("expected ErrUnsupportedMsgType, got %v", err)
+	}
+	for _, s := range []string{"memphis.event", "station_name_a", "attach a schema"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected error %q to contain %q", err.Error(), s)
+		}
+	}
+}
+
+func TestDefaultContentType(t *testing.T) {
+	p := Producer{}
+	type event struct{ Id int }
+	cases := []struct {
+		msg        any
+		schemaType SchemaType
+		expected   string
+	}{
+		{[]byte("raw"), "", ""},
+		{"raw", "", ""},
+		{map[string]interface{}{"id": 1}, "", "application/json"},
+		{event{Id: 1}, "", ""},
+		{[]byte("raw"), "protobuf", "application/x-protobuf"},
+		{event{Id: 1}, "json", "application/json"},
+		{"query", "graphql", "application/graphql"},
+		{event{Id: 1}, "custom", ""},
+	}
+	for _, c := range cases {
+		if ct := p.defaultContentType(c.msg, c.schemaType); ct != c.expected {
+			t.Errorf("%T with schema %q: expected %q, got %q", c.msg, c.schemaType, c.expected, ct)
+		}
+	}
+
+	if ct := (&Producer{jsonEncoder: true}).defaultContentType(event{Id: 1}, ""); ct != "application/json" {
+		t.Errorf("expected application/json for the json encoder, got %q", ct)
+	}
+	if ct := (&Producer{contentType: msgpackContentType}).defaultContentType(event{Id: 1}, ""); ct != msgpackContentType {
+		t.Errorf("expected %v for the msgpack encoder, got %q", msgpackContentType, ct)
+	}
+	if err := WithContentType("")(&ProduceOpts{}); err == nil {
+		t.Error("expected error for an empty content type")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(2, time.Second)
+	cb.now = func() time.Time { return now }
+	publishErr := errors.New("publish failed")
+
+	cb.done(false, errors.New("validation failed"))
+	cb.done(true, publishErr)
+	if !cb.allow() || cb.currentState() != CircuitClosed {
+		t.Fatal("breaker should be closed below the threshold")
+	}
+	cb.done(true, publishErr)
+	if cb.allow() || cb.currentState() != CircuitOpen {
+		t.Fatal("breaker should open after consecutive failures")
+	}
+
+	now = now.Add(time.Second)
+	if cb.currentState() != CircuitHalfOpen {
+		t.Fatal("breaker should be half open after the cooldown")
+	}
+	if !cb.allow() {
+		t.Fatal("a probe should be allowed after the cooldown")
+	}
+	if cb.allow() {
+		t.Fatal("a single probe should be allowed at a time")
+	}
+	cb.done(true, publishErr)
+	if cb.allow() {
+		t.Fatal("a failed probe should reopen the breaker")
+	}
+
+	now = now.Add(time.Second)
+	if !cb.allow() {
+		t.Fatal("a probe should be allowed after the cooldown")
+	}
+	cb.done(false, errors.New("validation failed"))
+	if !cb.allow() {
+		t.Fatal("a probe that wasn't published should release the probe slot")
+	}
+	cb.done(true, nil)
+	if cb.currentState() != CircuitClosed || !cb.allow() || !cb.allow() {
+		t.Fatal("a successful probe should close the breaker")
+	}
+}
+
+func TestCircuitBreakerProduce(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(1, time.Second)
+	cb.now = func() time.Time { return now }
+	c := &Conn{producers: map[*Producer]struct{}{}}
+	p := &Producer{conn: c, circuitBreaker: cb}
+	c.producers[p] = struct{}{}
+
+	// an async produce whose ack fails opens the breaker
+	opts := getDefaultProduceOpts()
+	failed := newTestPubAckFuture()
+	failed.err <- nats.ErrNoResponders
+	opts.awaitAck(p, failed, now, time.Second)
+	if cb.currentState() != CircuitOpen {
+		t.Fatalf("a failed async ack should count as a failure, state %v", cb.currentState())
+	}
+	if c.produceFailures != 1 || c.openCircuits() != 1 {
+		t.Errorf("expected 1 failure and 1 open circuit, got %v and %v", c.produceFailures, c.openCircuits())
+	}
+
+	// a produce rejected while the half-open probe is in flight doesn't release the probe
+	now = now.Add(time.Second)
+	if !cb.allow() {
+		t.Fatal("a probe should be allowed after the cooldown")
+	}
+	if err := opts.produce(p); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if cb.allow() {
+		t.Error("a rejected produce released the half-open probe")
+	}
+	if c.circuitRejections != 1 {
+		t.Errorf("expected 1 circuit rejection, got %v", c.circuitRejections)
+	}
+
+	acked := newTestPubAckFuture()
+	acked.ok <- &nats.PubAck{}
+	opts.awaitAck(p, acked, now, time.Second)
+	if cb.currentState() != CircuitClosed || c.openCircuits() != 0 {
+		t.Errorf("an acked async probe should close the breaker, state %v", cb.currentState())
+	}
+}
+
+func TestProducerRequest(t *testing.T) {
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		if pub.subject == "$memphis_producer_creations" {
+			return testReply(`{}`)
+		}
+		replyTo, correlationID := pub.header[replyToHeader], pub.header[correlationIdHeader]
+		if string(pub.data) == "unanswered" || len(replyTo) == 0 || len(correlationID) == 0 {
+			return testPubAck(nil)
+		}
+		return append(testPubAck(nil),
+			&nats.Msg{Subject: replyTo[0], Header: nats.Header{correlationIdHeader: {"other"}}, Data: []byte("not mine")},
+			&nats.Msg{Subject: replyTo[0], Header: nats.Header{correlationIdHeader: correlationID}, Data: []byte("pong")},
+		)
+	})
+	p, err := c.CreateProducer("station_name", "producer_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := p.Request([]byte("ping"), "", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.Data()) != "pong" {
+		t.Errorf("expected the reply with the request's correlation id, got %q", reply.Data())
+	}
+
+	reply, err = p.Request([]byte("ping"), "replies", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.Data()) != "pong" || reply.Station() != "replies" {
+		t.Errorf("expected the reply on the replies station, got %q on %v", reply.Data(), reply.Station())
+	}
+
+	if _, err := p.Request([]byte("unanswered"), "", 100*time.Millisecond); !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("expected ErrRequestTimeout, got %v", err)
+	}
+}
+
+func TestMsgRespondWithoutReplyTo(t *testing.T) {
+	msg := Msg{msg: &nats.Msg{Data: []byte("request")}}
+	if err := msg.Respond([]byte("reply")); err == nil {
+		t.Error("expected error responding to a message without a reply subject")
+	}
+}
+
+func TestProduceStallWait(t *testing.T) {
+	opts := ProduceOpts{AckWaitSec: 15, ctx: context.Background()}
+	if d, err := opts.stallWait(); err != nil || d != 15*time.Second {
+		t.Errorf("expected AckWaitSec without a deadline, got %v %v", d, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	opts.ctx = ctx
+	if d, err := opts.stallWait(); err != nil || d > time.Second || d <= 0 {
+		t.Errorf("expected the time left until the deadline, got %v %v", d, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	opts.ctx = ctx
+	if d, err := opts.stallWait(); err != nil || d != 15*time.Second {
+		t.Errorf("expected AckWaitSec before a later deadline, got %v %v", d, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	opts.ctx, opts.Context, opts.Timeout = ctx, nil, time.Second
+	if _, err := opts.stallWait(); !errors.Is(err, ErrProduceTimeout) {
+		t.Errorf("expected ErrProduceTimeout for an expired deadline, got %v", err)
+	}
+}
+
+func TestProducerConfigRoundTrip(t *testing.T) {
+	opts := getDefaultProducerOpts()
+	headers := Headers{}
+	headers.New()
+	if err := headers.Add("team", "payments"); err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []ProducerOpt{
+		ProducerGenUniqueSuffix(),
+		WithDefaultHeaders(headers),
+		WithMsgpackEncoding(),
+		WithCircuitBreaker(3, time.Second),
+		WithLazySchema(),
+	} {
+		if err := opt(&opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := opts.config("Station.A", "producer_name")
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ProducerConfig
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, decoded) {
+		t.Errorf("config should round-trip through JSON, got %+v", decoded)
+	}
+
+	restored := getDefaultProducerOpts()
+	for _, opt := range decoded.producerOpts() {
+		if err := opt(&restored); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if restored.DefaultEncoder == nil {
+		t.Error("msgpack encoding should be restored")
+	}
+	if !reflect.DeepEqual(restored.config("Station.A", "producer_name"), cfg) {
+		t.Error("restored options should have the same config")
+	}
+
+	p := Producer{config: cfg}
+	p.Config().DefaultHeaders["team"][0] = "changed"
+	if p.config.DefaultHeaders["team"][0] != "payments" {
+		t.Error("Config should return a copy of the default headers")
+	}
+
+	for _, invalid := range []ProducerConfig{
+		{RateLimitPerSecond: 10},
+		{CircuitBreakerFailures: 3},
+		{MaxInflight: -1},
+		{DefaultAckWaitSec: -1},
+		{GenUniqueSuffix: true, UniqueSuffixNumBytes: -1},
+		{DefaultHeaders: map[string][]string{"$memphis_a": {"1"}}},
+		{ContentType: "application/avro"},
+	} {
+		var err error
+		opts := getDefaultProducerOpts()
+		for _, opt := range invalid.producerOpts() {
+			if err = opt(&opts); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			t.Errorf("expected an error for config %+v", invalid)
+		}
+	}
+}
+
+func TestWithSubjectKey(t *testing.T) {
+	for _, key := range []string{"", "a.b", "a*", ">", "a b"} {
+		if err := WithSubjectKey(key)(&ProduceOpts{}); err == nil {
+			t.Errorf("expected error for key %q", key)
+		}
+	}
+
+	opts := ProduceOpts{}
+	if err := WithSubjectKey("customer-1")(&opts); err != nil {
+		t.Fatal(err)
+	}
+	p := Producer{stationName: "station_name", conn: &Conn{opts: getDefaultOptions()}}
+	if subj := p.Subject() + subjectKeySuffix(opts.SubjectKey); subj != "station_name.final.customer-1" {
+		t.Errorf("unexpected subject %v", subj)
+	}
+	if subjectKeySuffix("") != "" {
+		t.Error("messages without a key should be produced to the station's subject")
+	}
+}
+
+func TestMaxInflight(t *testing.T) {
+	p := &Producer{conn: &Conn{}, inflight: make(chan struct{}, 2)}
+	opts := getDefaultProduceOpts()
+	acked, lost := newTestPubAckFuture(), newTestPubAckFuture()
+	ackedDone, lostDone := make(chan struct{}), make(chan struct{})
+	for paf, done := range map[*testPubAckFuture]chan struct{}{acked: ackedDone, lost: lostDone} {
+		if err := p.acquireInflight(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		go func(paf *testPubAckFuture, done chan struct{}) {
+			opts.awaitAck(p, paf, time.Now(), 50*time.Millisecond)
+			close(done)
+		}(paf, done)
+	}
+	if p.InFlight() != 2 {
+		t.Errorf("expected 2 in-flight produces, got %v", p.InFlight())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.acquireInflight(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("a blocked produce should return when its context is done, got %v", err)
+	}
+
+	acked.ok <- &nats.PubAck{}
+	<-ackedDone
+	if err := p.acquireInflight(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p.releaseInflight()
+
+	// the lost ack releases its slot once the ack wait elapses
+	<-lostDone
+	if p.InFlight() != 0 {
+		t.Errorf("expected no in-flight produces, got %v", p.InFlight())
+	}
+
+	if (&Producer{}).InFlight() != 0 || (&Producer{}).acquireInflight(context.Background()) != nil {
+		t.Error("produces shouldn't be limited without WithMaxInflight")
+	}
+}
+
+func TestWithIdempotencyScope(t *testing.T) {
+	if err := WithIdempotencyScope("")(&ProduceOpts{}); err == nil {
+		t.Error("expected error for an empty scope")
+	}
+
+	headers := map[string][]string{"msg-id": {"343"}, nats.MsgIdHdr: {"raw-1"}}
+	scopeMsgIds(headers, "refunds")
+	if headers["msg-id"][0] != "refunds:343" || headers[nats.MsgIdHdr][0] != "refunds:raw-1" {
+		t.Errorf("unexpected scoped ids %v", headers)
+	}
+
+	headers = map[string][]string{"team": {"payments"}}
+	scopeMsgIds(headers, "refunds")
+	if len(headers) != 1 {
+		t.Error("messages without an id shouldn't get one")
+	}
+}
+
+func TestProduceInterceptors(t *testing.T) {
+	var calls []string
+	record := func(name string) ProduceInterceptor {
+		return func(next ProduceFunc) ProduceFunc {
+			return func(message any, opts *ProduceOpts) error {
+				calls = append(calls, name)
+				return next(message, opts)
+			}
+		}
+	}
+	upper := func(next ProduceFunc) ProduceFunc {
+		return func(message any, opts *ProduceOpts) error {
+			return next(strings.ToUpper(message.(string)), opts)
+		}
+	}
+	var produced any
+	// stands in for the actual produce, which needs a broker
+	capture := func(next ProduceFunc) ProduceFunc {
+		return func(message any, opts *ProduceOpts) error {
+			produced = message
+			return nil
+		}
+	}
+
+	opts := getDefaultProducerOpts()
+	for _, opt := range []ProducerOpt{WithInterceptor(record("first")), WithInterceptor(upper), WithInterceptor(record("second")), WithInterceptor(capture)} {
+		if err := opt(&opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WithInterceptor(nil)(&opts); err == nil {
+		t.Error("expected error for a nil interceptor")
+	}
+
+	p := &Producer{interceptors: opts.Interceptors}
+	if err := p.Produce("hey"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(calls, ",") != "first,second" {
+		t.Errorf("interceptors should run in the order they were added, got %v", calls)
+	}
+	if produced != "HEY" {
+		t.Errorf("interceptors should be able to change the message, got %v", produced)
+	}
+}
+
+func TestNatsHeadersConversion(t *testing.T) {
+	h := nats.Header{"trace-id": []string{"a", "b"}, "tenant": []string{"acme"}}
+	hdr, err := HeadersFromNats(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h["trace-id"][0] = "changed"
+	if values, _ := hdr.Get("trace-id"); !reflect.DeepEqual(values, []string{"a", "b"}) {
+		t.Errorf("expected the multi-value entry to be copied, got %v", values)
+	}
+
+	back := hdr.ToNats()
+	if !reflect.DeepEqual(back, nats.Header{"trace-id": []string{"a", "b"}, "tenant": []string{"acme"}}) {
+		t.Errorf("unexpected nats headers %v", back)
+	}
+	back["tenant"][0] = "changed"
+	if values, _ := hdr.Get("tenant"); values[0] != "acme" {
+		t.Error("converting to nats headers should copy the values")
+	}
+
+	if _, err := HeadersFromNats(nats.Header{"$memphis_producedBy": []string{"producer"}}); err == nil {
+		t.Error("expected error for a reserved key")
+	}
+}
+
+func TestProduceSeqAsync(t *testing.T) {
+	p := &Producer{}
+	if _, err := p.ProduceSeq("hey", AsyncProduce()); err == nil {
+		t.Error("expected error for an async produce")
+	}
+}
+
+func TestProduceSeq(t *testing.T) {
+	c, err := Connect("localhost", "root", "memphis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	p, err := c.CreateProducer("station_name_seq", "producer_name_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := p.ProduceSeq([]byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.ProduceSeq([]byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == 0 || second != first+1 {
+		t.Errorf("unexpected sequences %v, %v", first, second)
+	}
+}
+
+type binaryEvent struct{ id byte }
+
+func (e binaryEvent) MarshalBinary() ([]byte, error) {
+	return []byte{'e', e.id}, nil
+}
+
+func TestRawMsgBytesBinaryMarshaler(t *testing.T) {
+	p := Producer{encoder: json.Marshal, jsonEncoder: true}
+	data, err := p.rawMsgBytes(binaryEvent{id: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, []byte{'e', 7}) {
+		t.Errorf("expected MarshalBinary to take precedence over the encoder, got %v", data)
+	}
+	if ct := p.defaultContentType(binaryEvent{id: 7}, ""); ct != "" {
+		t.Errorf("binary messages should not be tagged, got %q", ct)
+	}
+}
+
+func TestProduceErrorCategories(t *testing.T) {
+	err := memphisError(categorizePublishErr(nats.ErrTimeout))
+	if !errors.Is(err, ErrBrokerTimeout) || !errors.Is(err, nats.ErrTimeout) {
+		t.Errorf("expected a broker timeout wrapping its cause, got %v", err)
+	}
+	if !isTransientProduceErr(err) {
+		t.Error("categorized timeouts should still be retried")
+	}
+	if err := categorizePublishErr(nats.ErrNoResponders); !errors.Is(err, ErrNoResponders) || errors.Is(err, ErrBrokerTimeout) {
+		t.Errorf("expected no responders, got %v", err)
+	}
+	if err := categorizePublishErr(nats.ErrMaxPayload); !errors.Is(err, ErrMessageTooLarge) || !errors.Is(err, nats.ErrMaxPayload) {
+		t.Errorf("expected a message too large error wrapping its cause, got %v", err)
+	}
+	other := errors.New("other")
+	if categorizePublishErr(other) != other {
+		t.Error("uncategorized errors should be returned as is")
+	}
+
+	c := &Conn{opts: getDefaultOptions()}
+	p := Producer{conn: c}
+	sd := schemaDetails{}
+	sd.setSchemaUpdateInit(SchemaUpdateInit{SchemaType: SchemaTypeJSON, ActiveVersion: SchemaVersion{Content: `{"type": "object", "required": ["id"]}`}})
+	if err := sd.compile(); err != nil {
+		t.Fatal(err)
+	}
+	sd.unmarshal = json.Unmarshal
+	_, err = p.validateMsgWithSchema(sd, []byte(`{}`), "", false)
+	if !errors.Is(err, ErrSchemaValidation) || !strings.HasPrefix(err.Error(), "Schema validation has failed: ") {
+		t.Errorf("expected a schema validation error, got %v", err)
+	}
+}
+
+func TestStationProduce(t *testing.T) {
+	c, err := Connect("localhost", "root", "memphis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s, err := c.CreateStation("station_name_produce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Destroy()
+
+	for _, msg := range []string{"first", "second"} {
+		if err := s.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, err := c.stationProducer(s.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.stationProducer(s.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("expected the station's producer to be reused")
+	}
+
+	c.destroyStationProducers()
+	if _, err := c.getProducerFromCache(s.Name, stationProducerName(c.ConnId)); err == nil {
+		t.Error("expected the station's producer to be destroyed")
+	}
+}
+
+func TestStationProduceAfterDestroy(t *testing.T) {
+	var creations int32
+	c := newTestJetStreamConn(t, func(pub testPublish) []*nats.Msg {
+		switch pub.subject {
+		case "$memphis_producer_creations":
+			atomic.AddInt32(&creations, 1)
+			return testReply(`{}`)
+		case "$memphis_producer_destructions":
+			return testReply("")
+		}
+		return testPubAck(nil)
+	})
+	s := &Station{Name: "station_name", conn: c}
+
+	if err := s.Produce([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.stationProducer(s.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.getProducerFromCache(s.Name, stationProducerName(c.ConnId)); err == nil {
+		t.Error("a destroyed producer should be removed from the cache")
+	}
+
+	if err := s.Produce([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.stationProducer(s.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&creations); second == first || n != 2 {
+		t.Errorf("expected a new producer to replace the destroyed one, got %v creations", n)
+	}
+}
+
+func TestOnLatencyAsync(t *testing.T) {
+	if err := OnLatency(nil)(&ProduceOpts{}); err == nil {
+		t.Error("expected error for a nil callback")
+	}
+
+	latencies := make(chan time.Duration, 1)
+	opts := getDefaultProduceOpts()
+	if err := OnLatency(func(d time.Duration) { latencies <- d })(&opts); err != nil {
+		t.Fatal(err)
+	}
+
+	acked, failed := newTestPubAckFuture(), newTestPubAckFuture()
+	acked.ok <- &nats.PubAck{}
+	failed.err <- nats.ErrNoResponders
+	start := time.Now().Add(-time.Second)
+	p := &Producer{conn: &Conn{}}
+	opts.awaitAck(p, failed, start, time.Second)
+	opts.awaitAck(p, acked, start, time.Second)
+
+	select {
+	case d := <-latencies:
+		if d < time.Second {
+			t.Errorf("expected latency measured from the publish, got %v", d)
+		}
+	default:
+		t.Fatal("latency wasn't reported for an acked produce")
+	}
+	if len(latencies) != 0 {
+		t.Error("latency reported for a failed produce")
+	}
+}
+
+func TestBinaryHeaders(t *testing.T) {
+	opts := ProduceOpts{}
+	if err := WithBinaryHeader("$memphis_checksum", []byte{1})(&opts); err == nil {
+		t.Error("expected error for a reserved key")
+	}
+	checksum := []byte{0x00, 0xff, 0x10, '\n', ':'}
+	p, pubs := newTestProducer(t)
+	err := p.Produce("event", WithBinaryHeader("checksum", checksum), MsgHeaders(Headers{MsgHeaders: map[string][]string{"source": {"svc"}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := <-pubs
+	if encoded := pub.header[binaryHeaderPrefix+"checksum"]; len(encoded) != 1 || encoded[0] != base64.StdEncoding.EncodeToString(checksum) {
+		t.Errorf("binary headers should be sent base64 encoded under %v, got %v", binaryHeaderPrefix, pub.header)
+	}
+	msg := Msg{msg: newTestJsMsg(time.Now(), pub.header)}
+	if got, ok := msg.BinaryHeader("checksum"); !ok || !bytes.Equal(got, checksum) {
+		t.Errorf("unexpected binary header %v", got)
+	}
+	if msg.GetHeaders()["source"] != "svc" {
+		t.Error("string headers should be produced alongside binary headers")
+	}
+	if _, ok := msg.BinaryHeader("missing"); ok {
+		t.Error("message without the binary header should not have it")
+	}
+}
+
+func TestMsgSchemaVersion(t *testing.T) {
+	p, pubs := newTestProducer(t)
+	if err := p.Produce([]byte(`{"id": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	msg := Msg{msg: newTestJsMsg(time.Now(), (<-pubs).header)}
+	if v := msg.SchemaVersion(); v != 0 {
+		t.Errorf("message of a station without a schema should have 0, got %v", v)
+	}
+
+	if err := p.conn.InjectSchema("station_name", SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 3, Content: `{"type": "object"}`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Produce([]byte(`{"id": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	msg = Msg{msg: newTestJsMsg(time.Now(), (<-pubs).header)}
+	if v := msg.SchemaVersion(); v != 3 {
+		t.Errorf("expected the validated schema version 3, got %v", v)
+	}
+}
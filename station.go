@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -138,6 +139,11 @@ func (c *Conn) CreateStation(Name string, opts ...StationOpt) (*Station, error)
 			}
 		}
 	}
+
+	if err := validateName(defaultOpts.Name, "station"); err != nil {
+		return nil, memphisError(err)
+	}
+
 	res, err := defaultOpts.createStation(c)
 	if err != nil && strings.Contains(err.Error(), "already exist") {
 		return res, nil
@@ -173,12 +179,55 @@ func (s *Station) Destroy() error {
 		return err
 	}
 
+	s.conn.producersMapMu.Lock()
 	pm := s.conn.getProducersMap()
 	pm.unsetStationProducers(s.Name)
+	s.conn.producersMapMu.Unlock()
 
 	return nil
 }
 
+// StationInfo - stored messages details of a station. The DLS message count and per-partition counts aren't
+// included, the broker's stream info this is read from doesn't have them.
+type StationInfo struct {
+	Name          string
+	Messages      uint64
+	Bytes         uint64
+	FirstSeq      uint64
+	LastSeq       uint64
+	Consumers     int
+	SchemaName    string
+	SchemaVersion int
+}
+
+// Station.Info - get the station's stored messages details, the schema details are known only
+// while a producer of this connection listens to the station's schema updates.
+func (s *Station) Info() (StationInfo, error) {
+	si, err := s.conn.brokerStreamInfo(getInternalName(s.Name))
+	if err != nil {
+		return StationInfo{}, memphisError(err)
+	}
+
+	info := StationInfo{
+		Name:       s.Name,
+		Messages:   si.State.Msgs,
+		Bytes:      si.State.Bytes,
+		FirstSeq:   si.State.FirstSeq,
+		LastSeq:    si.State.LastSeq,
+		Consumers:  si.State.Consumers,
+		SchemaName: s.SchemaName,
+	}
+
+	s.conn.stationUpdatesMu.RLock()
+	if sus, ok := s.conn.stationUpdatesSubs[getInternalName(s.Name)]; ok && sus.schemaDetails.name != "" {
+		info.SchemaName = sus.schemaDetails.name
+		info.SchemaVersion = sus.schemaDetails.activeVersion.VersionNumber
+	}
+	s.conn.stationUpdatesMu.RUnlock()
+
+	return info, nil
+}
+
 func (s *Station) getCreationSubject() string {
 	return "$memphis_station_creations"
 }
@@ -290,35 +339,114 @@ func SendSchemaFailedMsgToDls(sendSchemaFailedMsgToDls bool) StationOpt {
 type stationUpdateSub struct {
 	refCount        int
 	schemaUpdateCh  chan SchemaUpdate
+	done            chan struct{}
 	schemaUpdateSub *nats.Subscription
 	schemaDetails   schemaDetails
+	notify          func(SchemaUpdate)
+	notifyErr       func(error)
+	lastUpdate      time.Time
 }
 
+// SchemaUpdateHandler - handler for schema updates of a station.
+type SchemaUpdateHandler func(SchemaUpdate)
+
+// SchemaErrorHandler - handler for schema updates of a station that failed to compile.
+type SchemaErrorHandler func(stationName string, err error)
+
 type schemaDetails struct {
-	name          string
-	schemaType    string
-	activeVersion SchemaVersion
-	msgDescriptor protoreflect.MessageDescriptor
-	jsonSchema    *jsonschema.Schema
-	graphQlSchema *graphqlParse.Schema
+	name           string
+	schemaType     SchemaType
+	activeVersion  SchemaVersion
+	msgDescriptor  protoreflect.MessageDescriptor
+	msgDescriptors protoreflect.MessageDescriptors
+	jsonSchema     *jsonschema.Schema
+	graphQlSchema  *graphqlParse.Schema
+	compiled       bool
+	marshal        JSONMarshalFunc
+	unmarshal      JSONUnmarshalFunc
+}
+
+// listenToConfirmedSchemaUpdates - subscribes to the station's schema updates and waits for the broker to confirm
+// the subscription, retrying transient failures within the schema fetch timeout.
+func (c *Conn) listenToConfirmedSchemaUpdates(stationName string, sui *SchemaUpdateInit) error {
+	attempts := c.opts.SchemaFetchAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var deadline time.Time
+	var attemptTimeout time.Duration
+	if c.opts.SchemaFetchTimeout > 0 {
+		deadline = time.Now().Add(c.opts.SchemaFetchTimeout)
+		attemptTimeout = c.opts.SchemaFetchTimeout / time.Duration(attempts)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := c.tryListenToSchemaUpdates(stationName, sui, attemptTimeout)
+		if err == nil || attempt >= attempts || !isTransientSchemaFetchErr(err) {
+			return memphisError(err)
+		}
+		var wait time.Duration
+		if c.opts.SchemaFetchBackoff != nil {
+			wait = c.opts.SchemaFetchBackoff(attempt)
+		}
+		if !deadline.IsZero() && time.Now().Add(wait+attemptTimeout).After(deadline) {
+			return memphisError(err)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// tryListenToSchemaUpdates - subscribes to the station's schema updates and waits up to timeout for the broker
+// to confirm the subscription, the subscription is removed if it isn't confirmed.
+func (c *Conn) tryListenToSchemaUpdates(stationName string, sui *SchemaUpdateInit, timeout time.Duration) error {
+	release := c.acquireSchemaOp()
+	defer release()
+
+	if err := c.listenToSchemaUpdates(stationName, sui); err != nil {
+		return memphisError(err)
+	}
+	if err := c.confirmSchemaListener(timeout); err != nil {
+		if rmErr := c.removeSchemaUpdatesListener(stationName); rmErr != nil {
+			return memphisError(joinErrors(err, rmErr))
+		}
+		return memphisError(err)
+	}
+	return nil
 }
 
-func (c *Conn) listenToSchemaUpdates(stationName string) error {
+// listenToSchemaUpdates - subscribes to the station's schema updates, in case a new subscription is created
+// and sui is not nil the subscription's schema details are initialized from it without compiling.
+func (c *Conn) listenToSchemaUpdates(stationName string, sui *SchemaUpdateInit) error {
 	sn := getInternalName(stationName)
+
+	c.stationUpdatesMu.Lock()
+	defer c.stationUpdatesMu.Unlock()
+
 	sus, ok := c.stationUpdatesSubs[sn]
 	if !ok {
 		c.stationUpdatesSubs[sn] = &stationUpdateSub{
 			refCount:       1,
 			schemaUpdateCh: make(chan SchemaUpdate),
+			done:           make(chan struct{}),
 			schemaDetails:  schemaDetails{},
+			notify: func(update SchemaUpdate) {
+				c.notifySchemaUpdate(sn, stationName, update)
+			},
+			notifyErr: func(err error) {
+				c.notifySchemaError(stationName, err)
+			},
 		}
 		sus := c.stationUpdatesSubs[sn]
+		if sui != nil {
+			sus.schemaDetails.setSchemaUpdateInit(*sui)
+		}
 		schemaUpdatesSubject := fmt.Sprintf(schemaUpdatesSubjectTemplate, sn)
 		go sus.schemaUpdatesHandler(&c.stationUpdatesMu)
 		var err error
-		sus.schemaUpdateSub, err = c.brokerConn.Subscribe(schemaUpdatesSubject, sus.createMsgHandler())
+		sus.schemaUpdateSub, err = c.brokerConn.Subscribe(schemaUpdatesSubject, sus.createMsgHandler(c.opts.JSONUnmarshaler))
 		if err != nil {
-			close(sus.schemaUpdateCh)
+			close(sus.done)
+			delete(c.stationUpdatesSubs, sn)
 			return memphisError(err)
 		}
 
@@ -328,15 +456,19 @@ func (c *Conn) listenToSchemaUpdates(stationName string) error {
 	return nil
 }
 
-func (sus *stationUpdateSub) createMsgHandler() nats.MsgHandler {
+func (sus *stationUpdateSub) createMsgHandler(unmarshal JSONUnmarshalFunc) nats.MsgHandler {
 	return func(msg *nats.Msg) {
 		var update SchemaUpdate
-		err := json.Unmarshal(msg.Data, &update)
+		err := unmarshalJSON(unmarshal, msg.Data, &update)
 		if err != nil {
 			log.Printf("schema update unmarshal error: %v\n", memphisError(err))
 			return
 		}
-		sus.schemaUpdateCh <- update
+		// the listener may be removed while an update is delivered, the update is dropped then
+		select {
+		case sus.schemaUpdateCh <- update:
+		case <-sus.done:
+		}
 	}
 }
 
@@ -353,66 +485,289 @@ func (c *Conn) removeSchemaUpdatesListener(stationName string) error {
 
 	sus.refCount--
 	if sus.refCount <= 0 {
-		close(sus.schemaUpdateCh)
+		// removed before unsubscribing so a failed unsubscribe doesn't leave a stopped listener
+		// that the station's next producer would reuse
+		delete(c.stationUpdatesSubs, sn)
+		close(sus.done)
 		if err := sus.schemaUpdateSub.Unsubscribe(); err != nil {
 			return memphisError(err)
 		}
-		delete(c.stationUpdatesSubs, sn)
 	}
 
 	return nil
 }
 
-func (c *Conn) getSchemaDetails(stationName string) (schemaDetails, error) {
-	sn := getInternalName(stationName)
+// Conn.InjectSchema - set a station's schema locally, producers of this connection validate messages produced to the station
+// against it without listening to the station's schema updates. Meant for testing schema validation without a broker.
+func (c *Conn) InjectSchema(stationName string, sui SchemaUpdateInit) error {
+	sd := schemaDetails{}
+	sd.setSchemaUpdateInit(sui)
+	if err := sd.compile(); err != nil {
+		return memphisError(err)
+	}
+
+	c.stationUpdatesMu.Lock()
+	defer c.stationUpdatesMu.Unlock()
+	if c.injectedSchemas == nil {
+		c.injectedSchemas = make(map[string]schemaDetails)
+	}
+	c.injectedSchemas[getInternalName(stationName)] = sd
+	return nil
+}
 
+// Conn.ValidateMsg - validate a message against a station's schema, injected with Conn.InjectSchema or received
+// by a producer of this connection, and return the bytes that would be produced.
+func (c *Conn) ValidateMsg(stationName string, msg any) ([]byte, error) {
+	sd, err := c.getSchemaDetails(stationName)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	if sd.schemaType == "" {
+		return nil, memphisError(fmt.Errorf("station %v has no schema", stationName))
+	}
+	return sd.validateMsg(msg, "")
+}
+
+func (c *Conn) getInjectedSchema(stationName string) (schemaDetails, bool) {
 	c.stationUpdatesMu.RLock()
 	defer c.stationUpdatesMu.RUnlock()
+	sd, ok := c.injectedSchemas[getInternalName(stationName)]
+	return sd, ok
+}
+
+// getSchemaDetails - returns a snapshot of the station's schema details, compiling the schema if it wasn't compiled yet.
+// Callers should read the details once per message so a concurrent schema update or drop doesn't affect it mid-validation.
+func (c *Conn) getSchemaDetails(stationName string) (schemaDetails, error) {
+	sd, err := c.schemaDetailsSnapshot(stationName)
+	if err != nil {
+		return schemaDetails{}, err
+	}
+	sd.marshal, sd.unmarshal = c.opts.JSONMarshaler, c.opts.JSONUnmarshaler
+	return sd, nil
+}
 
+func (c *Conn) schemaDetailsSnapshot(stationName string) (schemaDetails, error) {
+	sn := getInternalName(stationName)
+	if sd, ok := c.getInjectedSchema(sn); ok {
+		return sd, nil
+	}
+
+	c.stationUpdatesMu.RLock()
 	sus, ok := c.stationUpdatesSubs[sn]
 	if !ok {
+		c.stationUpdatesMu.RUnlock()
 		return schemaDetails{}, memphisError(errors.New("station subscription doesn't exist"))
 	}
+	sd := sus.schemaDetails
+	c.stationUpdatesMu.RUnlock()
+
+	if sd.schemaType == "" || sd.compiled {
+		return sd, nil
+	}
+
+	// compile the snapshot, so a concurrent schema update doesn't change the details returned to the caller,
+	// it is cached only if the station's schema wasn't updated in the meantime
+	release := c.acquireSchemaOp()
+	err := sd.compile()
+	release()
+	if err != nil {
+		return schemaDetails{}, memphisError(err)
+	}
+
+	c.stationUpdatesMu.Lock()
+	defer c.stationUpdatesMu.Unlock()
+	if cur := &sus.schemaDetails; !cur.compiled && cur.sameVersion(sd) {
+		*cur = sd
+	}
+	return sd, nil
+}
+
+func (sd *schemaDetails) sameVersion(other schemaDetails) bool {
+	return sd.name == other.name &&
+		sd.schemaType == other.schemaType &&
+		sd.activeVersion.VersionNumber == other.activeVersion.VersionNumber
+}
+
+// Conn.acquireSchemaOp - waits for a slot for a schema subscription or compilation, the returned func releases it.
+func (c *Conn) acquireSchemaOp() func() {
+	if c.schemaOps == nil {
+		return func() {}
+	}
+	c.schemaOps <- struct{}{}
+	return func() { <-c.schemaOps }
+}
+
+// compileSchema - compiles the station's schema in case it wasn't compiled yet.
+func (c *Conn) compileSchema(stationName string) error {
+	sn := getInternalName(stationName)
 
-	return sus.schemaDetails, nil
+	// acquired before locking, holders of a slot may wait for the lock
+	release := c.acquireSchemaOp()
+	defer release()
+	c.stationUpdatesMu.Lock()
+	defer c.stationUpdatesMu.Unlock()
+
+	sus, ok := c.stationUpdatesSubs[sn]
+	if !ok {
+		return memphisError(errors.New("station subscription doesn't exist"))
+	}
+	if sus.schemaDetails.compiled {
+		return nil
+	}
+	return sus.schemaDetails.compile()
 }
 
 func (sus *stationUpdateSub) schemaUpdatesHandler(lock *sync.RWMutex) {
 	for {
-		update, ok := <-sus.schemaUpdateCh
-		if !ok {
+		var update SchemaUpdate
+		select {
+		case u, ok := <-sus.schemaUpdateCh:
+			if !ok {
+				return
+			}
+			update = u
+		case <-sus.done:
 			return
 		}
 
+		var err error
 		lock.Lock()
 		sd := &sus.schemaDetails
 		switch update.UpdateType {
 		case SchemaUpdateTypeInit:
-			sd.handleSchemaUpdateInit(update.Init)
+			err = sd.handleSchemaUpdateInit(update.Init)
 		case SchemaUpdateTypeDrop:
 			sd.handleSchemaUpdateDrop()
 		}
+		sus.lastUpdate = time.Now()
 		lock.Unlock()
-	}
-}
 
-func (sd *schemaDetails) handleSchemaUpdateInit(sui SchemaUpdateInit) {
-	sd.name = sui.SchemaName
-	sd.schemaType = sui.SchemaType
-	sd.activeVersion = sui.ActiveVersion
-	if sd.schemaType == "protobuf" {
-		if err := sd.compileDescriptor(); err != nil {
-			log.Println(err.Error())
+		if err != nil && sus.notifyErr != nil {
+			sus.notifyErr(err)
 		}
-	} else if sd.schemaType == "json" {
-		if err := sd.compileJsonSchema(); err != nil {
-			log.Println(err.Error())
+
+		if sus.notify != nil {
+			sus.notify(update)
 		}
-	} else if sd.schemaType == "graphql" {
-		if err := sd.compileGraphQl(); err != nil {
-			log.Println(err.Error())
+	}
+}
+
+// Station.LastSchemaUpdate - returns the time the last schema update of the station was processed by this client
+// and the active schema version number, the time is zero if no update was received since the station's schema was loaded.
+func (s *Station) LastSchemaUpdate() (time.Time, int) {
+	return s.conn.lastSchemaUpdate(s.Name)
+}
+
+// Station.HasSchema - whether a schema is attached to the station, read from the local cache.
+// The schema is cached while a producer of the station exists on this connection, ErrSchemaNotLoaded is returned otherwise.
+func (s *Station) HasSchema() (bool, error) {
+	sn := getInternalName(s.Name)
+
+	s.conn.stationUpdatesMu.RLock()
+	defer s.conn.stationUpdatesMu.RUnlock()
+	sus, ok := s.conn.stationUpdatesSubs[sn]
+	if !ok {
+		return false, memphisError(ErrSchemaNotLoaded)
+	}
+	return sus.schemaDetails.schemaType != "", nil
+}
+
+func (c *Conn) lastSchemaUpdate(stationName string) (time.Time, int) {
+	sn := getInternalName(stationName)
+
+	c.stationUpdatesMu.RLock()
+	defer c.stationUpdatesMu.RUnlock()
+	sus, ok := c.stationUpdatesSubs[sn]
+	if !ok {
+		return time.Time{}, 0
+	}
+	return sus.lastUpdate, sus.schemaDetails.activeVersion.VersionNumber
+}
+
+// Station.OnSchemaUpdate - register a handler called whenever a schema init or drop update is processed for this station.
+// Handlers are called on their own goroutine so a slow handler doesn't delay schema updates.
+func (s *Station) OnSchemaUpdate(handler SchemaUpdateHandler) {
+	s.conn.onSchemaUpdate(s.Name, handler)
+}
+
+func (c *Conn) onSchemaUpdate(stationName string, handler SchemaUpdateHandler) {
+	sn := getInternalName(stationName)
+
+	c.schemaUpdateCbsMu.Lock()
+	defer c.schemaUpdateCbsMu.Unlock()
+	c.schemaUpdateCbs[sn] = append(c.schemaUpdateCbs[sn], handler)
+}
+
+// Conn.notifySchemaUpdate - emits the update of the station with internal name sn as stationName, the name it was
+// first listened to with, and calls its handlers.
+func (c *Conn) notifySchemaUpdate(sn, stationName string, update SchemaUpdate) {
+	c.emitEvent(ConnEvent{Type: ConnEventSchemaUpdated, StationName: stationName, SchemaUpdate: update})
+
+	c.schemaUpdateCbsMu.RLock()
+	defer c.schemaUpdateCbsMu.RUnlock()
+	for _, handler := range c.schemaUpdateCbs[sn] {
+		go handler(update)
+	}
+}
+
+// Conn.OnSchemaError - register a handler called whenever a schema update pushed for a station fails to compile,
+// the previously active schema version, if it compiled, stays active.
+func (c *Conn) OnSchemaError(handler SchemaErrorHandler) {
+	c.schemaUpdateCbsMu.Lock()
+	defer c.schemaUpdateCbsMu.Unlock()
+	c.schemaErrHandler = handler
+}
+
+func (c *Conn) notifySchemaError(stationName string, err error) {
+	c.schemaUpdateCbsMu.RLock()
+	handler := c.schemaErrHandler
+	c.schemaUpdateCbsMu.RUnlock()
+
+	if handler == nil {
+		log.Println(err.Error())
+		return
+	}
+	go handler(stationName, err)
+}
+
+// schemaDetails.handleSchemaUpdateInit - compiles the pushed schema and makes it active,
+// on failure a previously compiled schema stays active.
+func (sd *schemaDetails) handleSchemaUpdateInit(sui SchemaUpdateInit) error {
+	newSd := schemaDetails{}
+	newSd.setSchemaUpdateInit(sui)
+	if err := newSd.compile(); err != nil {
+		if !sd.compiled {
+			*sd = newSd
 		}
+		return memphisError(fmt.Errorf("schema %v version %v failed to compile: %w", sui.SchemaName, sui.ActiveVersion.VersionNumber, err))
 	}
+	*sd = newSd
+	return nil
+}
+
+func (sd *schemaDetails) setSchemaUpdateInit(sui SchemaUpdateInit) {
+	*sd = schemaDetails{
+		name:          sui.SchemaName,
+		schemaType:    sui.SchemaType,
+		activeVersion: sui.ActiveVersion,
+	}
+}
+
+func (sd *schemaDetails) compile() error {
+	var err error
+	switch sd.schemaType {
+	case SchemaTypeProtobuf:
+		err = sd.compileDescriptor()
+	case SchemaTypeJSON:
+		err = sd.compileJsonSchema()
+	case SchemaTypeGraphQL:
+		err = sd.compileGraphQl()
+	}
+	if err != nil {
+		return memphisError(err)
+	}
+	sd.compiled = true
+	return nil
 }
 
 func (sd *schemaDetails) handleSchemaUpdateDrop() {
@@ -441,6 +796,7 @@ func (sd *schemaDetails) compileDescriptor() error {
 	msgDesc := msgsDesc.ByName(protoreflect.Name(sd.activeVersion.MessageStructName))
 
 	sd.msgDescriptor = msgDesc
+	sd.msgDescriptors = msgsDesc
 	return nil
 }
 
@@ -463,24 +819,145 @@ func (sd *schemaDetails) compileGraphQl() error {
 	return nil
 }
 
-func (sd *schemaDetails) validateMsg(msg any) ([]byte, error) {
+// SchemaValidator - validates messages produced to stations with a schema of a custom type
+// and returns the bytes to publish.
+type SchemaValidator interface {
+	Validate(msg any, version SchemaVersion) ([]byte, error)
+}
+
+// compiledSchemaValidator - a validator validating against the station's compiled schema, so the schema isn't
+// compiled per message.
+type compiledSchemaValidator interface {
+	validateCompiled(sd *schemaDetails, msg any, msgStructName string) ([]byte, error)
+}
+
+var (
+	schemaValidatorsMu sync.RWMutex
+	schemaValidators   = map[SchemaType]SchemaValidator{}
+	// builtinSchemaValidators - registered on init and restored when their registration is removed
+	builtinSchemaValidators = map[SchemaType]SchemaValidator{SchemaTypeProtobuf: protobufValidator{}}
+)
+
+func init() {
+	for typeName, v := range builtinSchemaValidators {
+		RegisterSchemaValidator(typeName, v)
+	}
+}
+
+// RegisterSchemaValidator - register a validator for a schema type, a validator registered for one of the
+// built-in types (protobuf, json, graphql) is used instead of the built-in validation. Registering a nil
+// validator removes the registration, restoring the built-in validator of built-in types.
+func RegisterSchemaValidator(typeName SchemaType, v SchemaValidator) {
+	schemaValidatorsMu.Lock()
+	defer schemaValidatorsMu.Unlock()
+	if v == nil {
+		v = builtinSchemaValidators[typeName]
+	}
+	if v == nil {
+		delete(schemaValidators, typeName)
+		return
+	}
+	schemaValidators[typeName] = v
+}
+
+// protobufValidator - the built-in validator of protobuf schemas.
+type protobufValidator struct{}
+
+// protobufValidator.Validate - validates msg against the version's message struct.
+func (protobufValidator) Validate(msg any, version SchemaVersion) ([]byte, error) {
+	msgDesc, err := protoMsgDescriptor(version)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+	sd := schemaDetails{
+		schemaType:     SchemaTypeProtobuf,
+		activeVersion:  version,
+		msgDescriptor:  msgDesc,
+		msgDescriptors: msgDesc.ParentFile().Messages(),
+	}
+	return sd.validateProtoMsg(msg, "")
+}
+
+func (protobufValidator) validateCompiled(sd *schemaDetails, msg any, msgStructName string) ([]byte, error) {
+	return sd.validateProtoMsg(msg, msgStructName)
+}
+
+func getSchemaValidator(typeName SchemaType) (SchemaValidator, bool) {
+	schemaValidatorsMu.RLock()
+	defer schemaValidatorsMu.RUnlock()
+	v, ok := schemaValidators[typeName]
+	return v, ok
+}
+
+// ErrUnsupportedSchemaType - the station's schema is of a type this client version can't validate messages against.
+type ErrUnsupportedSchemaType struct {
+	Type SchemaType
+}
+
+func (e ErrUnsupportedSchemaType) Error() string {
+	return fmt.Sprintf("unsupported schema type %q", e.Type)
+}
+
+// isSupportedSchemaType - checks the client can validate messages against schemas of the given type.
+func isSupportedSchemaType(schemaType SchemaType) bool {
+	switch schemaType {
+	case SchemaTypeJSON, SchemaTypeGraphQL:
+		return true
+	}
+	_, ok := getSchemaValidator(schemaType)
+	return ok
+}
+
+// schemaDetails.validateMsg - validates a message against the schema, msgStructName selects the protobuf message struct
+// to validate against, when empty the struct is matched by the message's type or the schema's default struct is used.
+func (sd *schemaDetails) validateMsg(msg any, msgStructName string) ([]byte, error) {
+	if validator, ok := getSchemaValidator(sd.schemaType); ok {
+		if compiled, ok := validator.(compiledSchemaValidator); ok {
+			return compiled.validateCompiled(sd, msg, msgStructName)
+		}
+		return validator.Validate(msg, sd.activeVersion)
+	}
+
 	switch sd.schemaType {
-	case "protobuf":
-		return sd.validateProtoMsg(msg)
-	case "json":
+	case SchemaTypeJSON:
 		return sd.validJsonSchemaMsg(msg)
-	case "graphql":
+	case SchemaTypeGraphQL:
 		return sd.validateGraphQlMsg(msg)
 	default:
-		return nil, memphisError(errors.New("Invalid schema type"))
+		return nil, memphisError(ErrUnsupportedSchemaType{Type: sd.schemaType})
 	}
 }
 
-func (sd *schemaDetails) validateProtoMsg(msg any) ([]byte, error) {
+// schemaDetails.getMsgDescriptor - selects the descriptor of the message struct to validate msg against.
+func (sd *schemaDetails) getMsgDescriptor(msg any, msgStructName string) (protoreflect.MessageDescriptor, error) {
+	if msgStructName == "" {
+		protoMsg, ok := msg.(protoreflect.ProtoMessage)
+		if !ok {
+			return sd.msgDescriptor, nil
+		}
+		msgStructName = string(protoMsg.ProtoReflect().Descriptor().Name())
+	}
+
+	if sd.msgDescriptors == nil {
+		return nil, memphisError(errors.New("schema is not compiled"))
+	}
+	msgDesc := sd.msgDescriptors.ByName(protoreflect.Name(msgStructName))
+	if msgDesc == nil {
+		return nil, memphisError(fmt.Errorf("message struct %v is not defined in schema %v", msgStructName, sd.name))
+	}
+	return msgDesc, nil
+}
+
+func (sd *schemaDetails) validateProtoMsg(msg any, msgStructName string) ([]byte, error) {
 	var (
 		msgBytes []byte
 		err      error
 	)
+	msgDescriptor, err := sd.getMsgDescriptor(msg, msgStructName)
+	if err != nil {
+		return nil, memphisError(err)
+	}
+
 	switch msg.(type) {
 	case protoreflect.ProtoMessage:
 		msgBytes, err = proto.Marshal(msg.(protoreflect.ProtoMessage))
@@ -490,11 +967,11 @@ func (sd *schemaDetails) validateProtoMsg(msg any) ([]byte, error) {
 	case []byte:
 		msgBytes = msg.([]byte)
 	case map[string]interface{}:
-		bytes, err := json.Marshal(msg)
+		bytes, err := marshalJSON(sd.marshal, msg)
 		if err != nil {
 			return nil, err
 		}
-		pMsg := dynamicpb.NewMessage(sd.msgDescriptor)
+		pMsg := dynamicpb.NewMessage(msgDescriptor)
 		err = protojson.Unmarshal(bytes, pMsg)
 		if err != nil {
 			return nil, memphisError(err)
@@ -504,10 +981,10 @@ func (sd *schemaDetails) validateProtoMsg(msg any) ([]byte, error) {
 			return nil, memphisError(err)
 		}
 	default:
-		return nil, memphisError(errors.New("Unsupported message type"))
+		return nil, memphisError(fmt.Errorf("%w %T for a station with a %v schema", ErrUnsupportedMsgType, msg, sd.schemaType))
 	}
 
-	protoMsg := dynamicpb.NewMessage(sd.msgDescriptor)
+	protoMsg := dynamicpb.NewMessage(msgDescriptor)
 	err = proto.Unmarshal(msgBytes, protoMsg)
 	if err != nil {
 		if strings.Contains(err.Error(), "cannot parse invalid wire-format data") {
@@ -529,13 +1006,13 @@ func (sd *schemaDetails) validJsonSchemaMsg(msg any) ([]byte, error) {
 	switch msg.(type) {
 	case []byte:
 		msgBytes = msg.([]byte)
-		if err := json.Unmarshal(msgBytes, &message); err != nil {
+		if err := unmarshalJSON(sd.unmarshal, msgBytes, &message); err != nil {
 			err = errors.New("Bad JSON format - " + err.Error())
 			return nil, memphisError(err)
 		}
 	case map[string]interface{}:
 		message = msg
-		msgBytes, err = json.Marshal(msg)
+		msgBytes, err = marshalJSON(sd.marshal, msg)
 		if err != nil {
 			return nil, memphisError(err)
 		}
@@ -543,15 +1020,15 @@ func (sd *schemaDetails) validJsonSchemaMsg(msg any) ([]byte, error) {
 	default:
 		msgType := reflect.TypeOf(msg).Kind()
 		if msgType == reflect.Struct {
-			msgBytes, err = json.Marshal(msg)
+			msgBytes, err = marshalJSON(sd.marshal, msg)
 			if err != nil {
 				return nil, memphisError(err)
 			}
-			if err := json.Unmarshal(msgBytes, &message); err != nil {
+			if err := unmarshalJSON(sd.unmarshal, msgBytes, &message); err != nil {
 				return nil, memphisError(err)
 			}
 		} else {
-			return nil, memphisError(errors.New("Unsupported message type"))
+			return nil, memphisError(fmt.Errorf("%w %T for a station with a %v schema", ErrUnsupportedMsgType, msg, sd.schemaType))
 		}
 	}
 	if err = sd.jsonSchema.Validate(message); err != nil {
@@ -571,13 +1048,15 @@ func (sd *schemaDetails) validateGraphQlMsg(msg any) ([]byte, error) {
 	switch msg.(type) {
 	case string:
 		message = fmt.Sprintf("%v", msg)
-		msgBytes, err = json.Marshal(msg)
+		msgBytes, err = marshalJSON(sd.marshal, msg)
 		if err != nil {
 			return nil, memphisError(err)
 		}
 	case []byte:
 		msgBytes = msg.([]byte)
 		message = string(msgBytes)
+	default:
+		return nil, memphisError(fmt.Errorf("%w %T for a station with a %v schema", ErrUnsupportedMsgType, msg, sd.schemaType))
 	}
 
 	validateResult := sd.graphQlSchema.Validate(message)
@@ -597,3 +1076,235 @@ func (sd *schemaDetails) validateGraphQlMsg(msg any) ([]byte, error) {
 	}
 	return msgBytes, nil
 }
+
+// SchemaChangeType - the kind of a field change between two schema versions.
+type SchemaChangeType int
+
+const (
+	FieldAdded SchemaChangeType = iota
+	FieldRemoved
+	FieldRetyped
+	FieldRenamed
+	FieldRequirementChanged
+)
+
+func (t SchemaChangeType) String() string {
+	return [...]string{"added", "removed", "retyped", "renamed", "requirement_changed"}[t]
+}
+
+// FieldChange - a field change between two schema versions, Field is the field's path from the root message
+// (e.g. "order.total"). Backward is whether consumers using the candidate version can still read messages
+// of the current version, Forward whether consumers using the current version can read messages of the candidate.
+type FieldChange struct {
+	Field    string
+	Change   SchemaChangeType
+	Detail   string
+	Backward bool
+	Forward  bool
+}
+
+// CompatibilityReport - the field changes between two schema versions and whether the change is compatible.
+type CompatibilityReport struct {
+	Changes            []FieldChange
+	BackwardCompatible bool
+	ForwardCompatible  bool
+}
+
+// CompatibilityReport.Incompatibilities - the changes breaking backward or forward compatibility.
+func (r CompatibilityReport) Incompatibilities() []FieldChange {
+	var changes []FieldChange
+	for _, change := range r.Changes {
+		if !change.Backward || !change.Forward {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+func (r *CompatibilityReport) add(change FieldChange) {
+	r.Changes = append(r.Changes, change)
+	r.BackwardCompatible = r.BackwardCompatible && change.Backward
+	r.ForwardCompatible = r.ForwardCompatible && change.Forward
+}
+
+// CheckSchemaCompatibility - compares a candidate schema version with the current one, without calling the broker.
+// Versions with a descriptor are compared as protobuf schemas, by field number, starting from the versions'
+// message structs. Other versions are compared as JSON schemas, by their object properties.
+func CheckSchemaCompatibility(current, candidate SchemaVersion) (CompatibilityReport, error) {
+	report := CompatibilityReport{BackwardCompatible: true, ForwardCompatible: true}
+	if (current.Descriptor == "") != (candidate.Descriptor == "") {
+		return CompatibilityReport{}, memphisError(errors.New("can't compare a protobuf schema with a JSON schema"))
+	}
+
+	if current.Descriptor != "" {
+		cur, err := protoMsgDescriptor(current)
+		if err != nil {
+			return CompatibilityReport{}, memphisError(fmt.Errorf("current version: %w", err))
+		}
+		cand, err := protoMsgDescriptor(candidate)
+		if err != nil {
+			return CompatibilityReport{}, memphisError(fmt.Errorf("candidate version: %w", err))
+		}
+		compareProtoMsgs("", cur, cand, &report, map[[2]protoreflect.FullName]bool{})
+		return report, nil
+	}
+
+	var cur, cand map[string]any
+	if err := json.Unmarshal([]byte(current.Content), &cur); err != nil {
+		return CompatibilityReport{}, memphisError(fmt.Errorf("current version: %w", err))
+	}
+	if err := json.Unmarshal([]byte(candidate.Content), &cand); err != nil {
+		return CompatibilityReport{}, memphisError(fmt.Errorf("candidate version: %w", err))
+	}
+	compareJsonSchemas("", cur, cand, &report)
+	return report, nil
+}
+
+// protoMsgDescriptor - the descriptor of the version's message struct.
+func protoMsgDescriptor(v SchemaVersion) (protoreflect.MessageDescriptor, error) {
+	descriptorSet := descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal([]byte(v.Descriptor), &descriptorSet); err != nil {
+		return nil, err
+	}
+	files, err := protodesc.NewFiles(&descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgDesc protoreflect.MessageDescriptor
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		msgDesc = fd.Messages().ByName(protoreflect.Name(v.MessageStructName))
+		return msgDesc == nil
+	})
+	if msgDesc == nil {
+		return nil, fmt.Errorf("message struct %q not found", v.MessageStructName)
+	}
+	return msgDesc, nil
+}
+
+func compareProtoMsgs(path string, cur, cand protoreflect.MessageDescriptor, report *CompatibilityReport, visited map[[2]protoreflect.FullName]bool) {
+	// recursive messages are compared once
+	pair := [2]protoreflect.FullName{cur.FullName(), cand.FullName()}
+	if visited[pair] {
+		return
+	}
+	visited[pair] = true
+
+	curFields, candFields := cur.Fields(), cand.Fields()
+	for i := 0; i < curFields.Len(); i++ {
+		curField := curFields.Get(i)
+		field := fieldPath(path, string(curField.Name()))
+		candField := candFields.ByNumber(curField.Number())
+		if candField == nil {
+			// readers of the current version fail only on missing required fields
+			report.add(FieldChange{Field: field, Change: FieldRemoved, Backward: true, Forward: curField.Cardinality() != protoreflect.Required})
+			continue
+		}
+		if curType, candType := protoFieldType(curField), protoFieldType(candField); curType != candType {
+			report.add(FieldChange{Field: field, Change: FieldRetyped, Detail: fmt.Sprintf("type changed from %v to %v", curType, candType)})
+			continue
+		}
+		if curField.Name() != candField.Name() {
+			report.add(FieldChange{Field: field, Change: FieldRenamed, Detail: fmt.Sprintf("renamed to %v", candField.Name()), Backward: true, Forward: true})
+		}
+		if curField.Kind() == protoreflect.MessageKind && !curField.IsMap() {
+			compareProtoMsgs(field, curField.Message(), candField.Message(), report, visited)
+		}
+	}
+	for i := 0; i < candFields.Len(); i++ {
+		candField := candFields.Get(i)
+		if curFields.ByNumber(candField.Number()) == nil {
+			report.add(FieldChange{Field: fieldPath(path, string(candField.Name())), Change: FieldAdded, Backward: candField.Cardinality() != protoreflect.Required, Forward: true})
+		}
+	}
+}
+
+// protoFieldType - the field's type including its cardinality, e.g. "repeated string" or "map<string, int32>".
+func protoFieldType(fd protoreflect.FieldDescriptor) string {
+	if fd.IsMap() {
+		return fmt.Sprintf("map<%v, %v>", protoFieldType(fd.MapKey()), protoFieldType(fd.MapValue()))
+	}
+	typ := fd.Kind().String()
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		typ = string(fd.Message().Name())
+	case protoreflect.EnumKind:
+		typ = string(fd.Enum().Name())
+	}
+	if fd.IsList() {
+		return "repeated " + typ
+	}
+	return typ
+}
+
+func compareJsonSchemas(path string, cur, cand map[string]any, report *CompatibilityReport) {
+	curProps, candProps := jsonSchemaProps(cur), jsonSchemaProps(cand)
+	curRequired, candRequired := jsonSchemaRequired(cur), jsonSchemaRequired(cand)
+	curClosed, candClosed := cur["additionalProperties"] == false, cand["additionalProperties"] == false
+
+	names := make([]string, 0, len(curProps)+len(candProps))
+	for name := range curProps {
+		names = append(names, name)
+	}
+	for name := range candProps {
+		if _, ok := curProps[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := fieldPath(path, name)
+		curProp, inCur := curProps[name]
+		candProp, inCand := candProps[name]
+		switch {
+		case !inCand:
+			report.add(FieldChange{Field: field, Change: FieldRemoved, Backward: !candClosed, Forward: !curRequired[name]})
+		case !inCur:
+			report.add(FieldChange{Field: field, Change: FieldAdded, Backward: !candRequired[name], Forward: !curClosed})
+		default:
+			curType, candType := fmt.Sprint(curProp["type"]), fmt.Sprint(candProp["type"])
+			if curType != candType {
+				report.add(FieldChange{Field: field, Change: FieldRetyped, Detail: fmt.Sprintf("type changed from %v to %v", curType, candType)})
+				continue
+			}
+			if curRequired[name] != candRequired[name] {
+				detail := "no longer required"
+				if candRequired[name] {
+					detail = "became required"
+				}
+				report.add(FieldChange{Field: field, Change: FieldRequirementChanged, Detail: detail, Backward: !candRequired[name], Forward: !curRequired[name]})
+			}
+			compareJsonSchemas(field, curProp, candProp, report)
+		}
+	}
+}
+
+func jsonSchemaProps(schema map[string]any) map[string]map[string]any {
+	props := map[string]map[string]any{}
+	raw, _ := schema["properties"].(map[string]any)
+	for name, prop := range raw {
+		if prop, ok := prop.(map[string]any); ok {
+			props[name] = prop
+		}
+	}
+	return props
+}
+
+func jsonSchemaRequired(schema map[string]any) map[string]bool {
+	required := map[string]bool{}
+	raw, _ := schema["required"].([]any)
+	for _, name := range raw {
+		if name, ok := name.(string); ok {
+			required[name] = true
+		}
+	}
+	return required
+}
+
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
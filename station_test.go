@@ -14,8 +14,22 @@
 package memphis
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 func TestCreateStation(t *testing.T) {
@@ -76,3 +90,775 @@ func TestCreateStationWithDefaults(t *testing.T) {
 	}
 	s.Destroy()
 }
+
+func TestSchemaDetailsCompile(t *testing.T) {
+	sd := schemaDetails{}
+	sd.setSchemaUpdateInit(SchemaUpdateInit{
+		SchemaName: "schema_name",
+		SchemaType: "json",
+		ActiveVersion: SchemaVersion{
+			VersionNumber: 1,
+			Content:       `{"type": "object", "required": ["id"]}`,
+		},
+	})
+	if sd.compiled {
+		t.Error("schema should not be compiled before compile is called")
+	}
+
+	if err := sd.compile(); err != nil {
+		t.Error(err)
+	}
+	if !sd.compiled || sd.jsonSchema == nil {
+		t.Error("schema should be compiled")
+	}
+
+	sd.setSchemaUpdateInit(SchemaUpdateInit{SchemaName: "schema_name", SchemaType: "json", ActiveVersion: SchemaVersion{Content: "{"}})
+	if err := sd.compile(); err == nil {
+		t.Error("expected compilation error for invalid schema")
+	}
+	if sd.compiled {
+		t.Error("failed compilation should not mark the schema compiled")
+	}
+}
+
+func TestValidateProtoMsgStructSelection(t *testing.T) {
+	fileDesc := &descriptorpb.FileDescriptorProto{
+		Name:   proto.String("schema_name_1.proto"),
+		Syntax: proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Order"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("id"), JsonName: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				},
+			},
+			{
+				Name: proto.String("Refund"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("amount"), JsonName: proto.String("amount"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				},
+			},
+		},
+	}
+	descriptor, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fileDesc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd := schemaDetails{}
+	sd.setSchemaUpdateInit(SchemaUpdateInit{
+		SchemaName: "schema_name",
+		SchemaType: "protobuf",
+		ActiveVersion: SchemaVersion{
+			VersionNumber:     1,
+			Descriptor:        string(descriptor),
+			MessageStructName: "Order",
+		},
+	})
+	if err := sd.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	refund := dynamicpb.NewMessage(sd.msgDescriptors.ByName("Refund"))
+	refund.Set(refund.Descriptor().Fields().ByName("amount"), protoreflect.ValueOfInt64(10))
+	if _, err := sd.validateMsg(refund, ""); err != nil {
+		t.Errorf("message should be matched to its struct: %v", err)
+	}
+
+	if _, err := sd.validateMsg(map[string]interface{}{"amount": "10"}, "Refund"); err != nil {
+		t.Errorf("forced struct should be used: %v", err)
+	}
+
+	if _, err := sd.validateMsg(map[string]interface{}{"id": "1"}, "Missing"); err == nil {
+		t.Error("expected error for a struct not defined in the schema")
+	}
+}
+
+type upperCaseValidator struct{}
+
+func (v upperCaseValidator) Validate(msg any, version SchemaVersion) ([]byte, error) {
+	str, ok := msg.(string)
+	if !ok || strings.ToUpper(str) != str {
+		return nil, errors.New("message has to be an upper case string")
+	}
+	return []byte(str), nil
+}
+
+func TestRegisterSchemaValidator(t *testing.T) {
+	RegisterSchemaValidator("upper", upperCaseValidator{})
+	defer RegisterSchemaValidator("upper", nil)
+
+	sd := schemaDetails{}
+	sd.setSchemaUpdateInit(SchemaUpdateInit{SchemaName: "schema_name", SchemaType: "upper"})
+	if err := sd.compile(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := sd.validateMsg("HEY", ""); err != nil {
+		t.Error(err)
+	}
+	if _, err := sd.validateMsg("hey", ""); err == nil {
+		t.Error("expected the registered validator to reject the message")
+	}
+}
+
+func TestBuiltinProtobufValidator(t *testing.T) {
+	v, ok := getSchemaValidator(SchemaTypeProtobuf)
+	if !ok {
+		t.Fatal("the protobuf validator should be registered on init")
+	}
+
+	fileDesc := &descriptorpb.FileDescriptorProto{
+		Name:   proto.String("order.proto"),
+		Syntax: proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Order"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("id"), JsonName: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			},
+		}},
+	}
+	descriptor, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fileDesc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := SchemaVersion{Descriptor: string(descriptor), MessageStructName: "Order"}
+	if _, err := v.Validate(map[string]interface{}{"id": "1"}, version); err != nil {
+		t.Errorf("expected a valid message, got %v", err)
+	}
+	if _, err := v.Validate(map[string]interface{}{"id": 1}, version); err == nil {
+		t.Error("expected the registered protobuf validator to reject the message")
+	}
+
+	RegisterSchemaValidator(SchemaTypeProtobuf, upperCaseValidator{})
+	if v, _ := getSchemaValidator(SchemaTypeProtobuf); v != (upperCaseValidator{}) {
+		t.Error("a registered validator should replace the built-in one")
+	}
+	RegisterSchemaValidator(SchemaTypeProtobuf, nil)
+	if v, _ := getSchemaValidator(SchemaTypeProtobuf); v != (protobufValidator{}) {
+		t.Error("removing the registration should restore the built-in validator")
+	}
+}
+
+func TestHandleSchemaUpdateInitKeepsValidVersion(t *testing.T) {
+	sd := schemaDetails{}
+	valid := SchemaUpdateInit{SchemaName: "schema_name", SchemaType: "json", ActiveVersion: SchemaVersion{VersionNumber: 1, Content: `{"type": "object"}`}}
+	if err := sd.handleSchemaUpdateInit(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := SchemaUpdateInit{SchemaName: "schema_name", SchemaType: "json", ActiveVersion: SchemaVersion{VersionNumber: 2, Content: "{"}}
+	err := sd.handleSchemaUpdateInit(invalid)
+	if err == nil || !strings.Contains(err.Error(), "schema_name version 2") {
+		t.Errorf("expected an error naming the schema and version, got %v", err)
+	}
+	if !sd.compiled || sd.activeVersion.VersionNumber != 1 {
+		t.Error("the previously compiled version should stay active")
+	}
+
+	sd = schemaDetails{}
+	if err := sd.handleSchemaUpdateInit(invalid); err == nil {
+		t.Error("expected compilation error")
+	}
+	if sd.compiled || sd.activeVersion.VersionNumber != 2 {
+		t.Error("without a valid version the new version should be set uncompiled")
+	}
+}
+
+func TestInjectSchema(t *testing.T) {
+	c := &Conn{}
+	err := c.InjectSchema("station.a", SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 1, Content: `{"type": "object", "required": ["id"]}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ValidateMsg("station.a", map[string]interface{}{"id": 1}); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.ValidateMsg("station.a", map[string]interface{}{"name": "a"}); err == nil {
+		t.Error("expected validation error for a message missing a required field")
+	}
+
+	p := Producer{stationName: getInternalName("station.a"), conn: c}
+	if _, _, err := p.validateMsg(map[string]interface{}{"id": 1}, nil, "", false); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := c.ValidateMsg("station_b", []byte("{}")); err == nil {
+		t.Error("expected error for a station without a schema")
+	}
+	if err := c.InjectSchema("station_c", SchemaUpdateInit{SchemaType: "json", ActiveVersion: SchemaVersion{Content: "{"}}); err == nil {
+		t.Error("expected compilation error for an invalid schema")
+	}
+}
+
+func TestSchemaDropDuringProduce(t *testing.T) {
+	sn := getInternalName("station_name_a")
+	sus := &stationUpdateSub{refCount: 1, schemaUpdateCh: make(chan SchemaUpdate)}
+	c := &Conn{stationUpdatesSubs: map[string]*stationUpdateSub{sn: sus}}
+	go sus.schemaUpdatesHandler(&c.stationUpdatesMu)
+	defer close(sus.schemaUpdateCh)
+
+	init := SchemaUpdate{UpdateType: SchemaUpdateTypeInit, Init: SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 1, Content: `{"type": "object"}`},
+	}}
+	drop := SchemaUpdate{UpdateType: SchemaUpdateTypeDrop}
+
+	p := Producer{stationName: sn, conn: c, schemaListening: true}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, _, err := p.validateMsg(map[string]interface{}{"id": j}, nil, "", false); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		sus.schemaUpdateCh <- init
+		sus.schemaUpdateCh <- drop
+	}
+	wg.Wait()
+
+	sus.schemaUpdateCh <- init
+	sus.schemaUpdateCh <- drop
+	// updates are handled in order, once a no-op update is received the drop was applied
+	sus.schemaUpdateCh <- SchemaUpdate{}
+	sd, err := c.getSchemaDetails(sn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.schemaType != "" {
+		t.Error("produces after a drop should fall back to raw bytes")
+	}
+}
+
+func TestValidateMsgPreEncoded(t *testing.T) {
+	fileDesc := &descriptorpb.FileDescriptorProto{
+		Name:   proto.String("schema_name_3.proto"),
+		Syntax: proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Order"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("id"), JsonName: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				},
+			},
+		},
+	}
+	descriptor, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fileDesc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Conn{}
+	err = c.InjectSchema("station_name", SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "protobuf",
+		ActiveVersion: SchemaVersion{VersionNumber: 3, Descriptor: string(descriptor), MessageStructName: "Order"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Producer{stationName: getInternalName("station_name"), conn: c}
+	malformed := []byte{0xff, 0xff, 0xff}
+	if _, _, err := p.validateMsg(malformed, nil, "", false); err == nil {
+		t.Error("expected malformed bytes to fail validation")
+	}
+
+	data, sd, err := p.validateMsg(malformed, nil, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, malformed) {
+		t.Error("pre-encoded bytes should be produced as is")
+	}
+	if sd.schemaType != "protobuf" || sd.activeVersion.VersionNumber != 3 {
+		t.Errorf("unexpected schema details: %v %v", sd.schemaType, sd.activeVersion.VersionNumber)
+	}
+
+	if _, _, err := p.validateMsg(map[string]interface{}{"id": "1"}, nil, "", true); !errors.Is(err, ErrUnsupportedMsgType) {
+		t.Errorf("expected unsupported type error, got %v", err)
+	}
+}
+
+func TestLastSchemaUpdate(t *testing.T) {
+	sn := getInternalName("station_name")
+	sus := &stationUpdateSub{refCount: 1, schemaUpdateCh: make(chan SchemaUpdate)}
+	c := &Conn{stationUpdatesSubs: map[string]*stationUpdateSub{sn: sus}}
+	s := Station{Name: "station_name", conn: c}
+	go sus.schemaUpdatesHandler(&c.stationUpdatesMu)
+	defer close(sus.schemaUpdateCh)
+
+	if ts, version := s.LastSchemaUpdate(); !ts.IsZero() || version != 0 {
+		t.Errorf("expected no update, got %v %v", ts, version)
+	}
+
+	before := time.Now()
+	sus.schemaUpdateCh <- SchemaUpdate{UpdateType: SchemaUpdateTypeInit, Init: SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 2, Content: `{"type": "object"}`},
+	}}
+	// updates are handled in order, once a no-op update is received the init was applied
+	sus.schemaUpdateCh <- SchemaUpdate{}
+
+	ts, version := s.LastSchemaUpdate()
+	if ts.Before(before) || version != 2 {
+		t.Errorf("unexpected last update %v %v", ts, version)
+	}
+}
+
+func TestWithSchema(t *testing.T) {
+	opts := ProduceOpts{}
+	if err := WithSchema(SchemaUpdateInit{SchemaType: "avro"})(&opts); err == nil {
+		t.Error("expected error for an unsupported schema type")
+	}
+	if err := WithSchema(SchemaUpdateInit{SchemaType: "json", ActiveVersion: SchemaVersion{Content: "{"}})(&opts); err == nil {
+		t.Error("expected compilation error for an invalid schema")
+	}
+
+	tenantSchema := SchemaUpdateInit{
+		SchemaName:    "tenant_a",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 1, Content: `{"type": "object", "required": ["tenant"]}`},
+	}
+	if err := WithSchema(tenantSchema)(&opts); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Conn{}
+	if err := c.InjectSchema("station_name", SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 1, Content: `{"type": "object", "required": ["id"]}`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := Producer{stationName: getInternalName("station_name"), conn: c}
+	if _, err := p.validateMsgWithSchema(*opts.schema, map[string]interface{}{"tenant": "a"}, "", false); err != nil {
+		t.Error(err)
+	}
+	if _, err := p.validateMsgWithSchema(*opts.schema, map[string]interface{}{"id": 1}, "", false); err == nil {
+		t.Error("expected the message to be validated against the supplied schema")
+	}
+
+	sd, err := c.getSchemaDetails("station_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.name != "schema_name" {
+		t.Error("the station's schema should not be changed")
+	}
+}
+
+func TestProducerSchemaDetails(t *testing.T) {
+	sn := getInternalName("station_name")
+	sus := &stationUpdateSub{refCount: 1}
+	sus.schemaDetails.setSchemaUpdateInit(SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "protobuf",
+		ActiveVersion: SchemaVersion{VersionNumber: 4, MessageStructName: "Order"},
+	})
+	c := &Conn{stationUpdatesSubs: map[string]*stationUpdateSub{sn: sus}}
+
+	p := Producer{stationName: sn, conn: c, schemaListening: true}
+	details, err := p.SchemaDetails()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := SchemaDetails{Name: "schema_name", Type: "protobuf", ActiveVersion: 4, MessageStructName: "Order"}
+	if details != expected {
+		t.Errorf("unexpected schema details %+v", details)
+	}
+
+	lazy := Producer{stationName: "station_b", conn: c, pendingSchemaInit: SchemaUpdateInit{SchemaName: "schema_b", SchemaType: "json"}}
+	if details, err := lazy.SchemaDetails(); err != nil || details.Name != "schema_b" {
+		t.Errorf("expected the pending schema of a lazy producer, got %+v %v", details, err)
+	}
+
+	noSchema := Producer{stationName: "station_c", conn: c}
+	if _, err := noSchema.SchemaDetails(); err == nil {
+		t.Error("expected error for a station without a schema")
+	}
+}
+
+func TestHasSchema(t *testing.T) {
+	sn := getInternalName("station_name")
+	sus := &stationUpdateSub{refCount: 1}
+	sus.schemaDetails.setSchemaUpdateInit(SchemaUpdateInit{SchemaName: "schema_name", SchemaType: "json"})
+	c := &Conn{stationUpdatesSubs: map[string]*stationUpdateSub{sn: sus}}
+
+	p := Producer{stationName: sn, conn: c, schemaListening: true}
+	if has, err := p.HasSchema(); err != nil || !has {
+		t.Errorf("expected a schema, got %v %v", has, err)
+	}
+	if has, err := (&Station{Name: sn, conn: c}).HasSchema(); err != nil || !has {
+		t.Errorf("expected the station to have a schema, got %v %v", has, err)
+	}
+
+	noSchema := Producer{stationName: "station_b", conn: c}
+	if has, err := noSchema.HasSchema(); err != nil || has {
+		t.Errorf("expected no schema, got %v %v", has, err)
+	}
+
+	unknown := Producer{stationName: "station_c", conn: c, schemaListening: true}
+	if _, err := unknown.HasSchema(); !errors.Is(err, ErrSchemaNotLoaded) {
+		t.Errorf("expected ErrSchemaNotLoaded, got %v", err)
+	}
+	if _, err := (&Station{Name: "station_c", conn: c}).HasSchema(); !errors.Is(err, ErrSchemaNotLoaded) {
+		t.Errorf("expected ErrSchemaNotLoaded, got %v", err)
+	}
+}
+
+// newTestBrokerConn - connects to a minimal NATS server answering pings and delivering an empty schema update
+// for every subscription, the first ping after connecting is answered after pongDelay.
+func newTestBrokerConn(t *testing.T, pongDelay time.Duration) *nats.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.9.0\",\"headers\":true,\"max_payload\":1048576}\r\n")
+				r := bufio.NewReader(conn)
+				pings := 0
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					if len(fields) == 0 {
+						continue
+					}
+					switch fields[0] {
+					case "PING":
+						pings++
+						if pings == 2 {
+							time.Sleep(pongDelay)
+						}
+						fmt.Fprint(conn, "PONG\r\n")
+					case "SUB":
+						fmt.Fprintf(conn, "MSG %v %v 2\r\n{}\r\n", fields[1], fields[len(fields)-1])
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	nc, err := nats.Connect("nats://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestSchemaUpdatesListenerRefCount(t *testing.T) {
+	c := &Conn{brokerConn: newTestBrokerConn(t, 0), stationUpdatesSubs: make(map[string]*stationUpdateSub), opts: getDefaultOptions()}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := c.listenToSchemaUpdates("station_name", nil); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := c.removeSchemaUpdatesListener("station_name"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(c.stationUpdatesSubs) != 0 {
+		t.Error("the listener should be removed once all its users are destroyed")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := c.listenToSchemaUpdates("station_name", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.removeSchemaUpdatesListener("station_name"); err != nil {
+		t.Fatal(err)
+	}
+	sus, ok := c.stationUpdatesSubs[getInternalName("station_name")]
+	if !ok || sus.refCount != 1 || !sus.schemaUpdateSub.IsValid() {
+		t.Fatal("the listener should be kept while it has users")
+	}
+	if err := c.removeSchemaUpdatesListener("station_name"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.removeSchemaUpdatesListener("station_name"); err == nil {
+		t.Error("expected error removing a listener that doesn't exist")
+	}
+}
+
+func TestSchemaUpdateEventStationName(t *testing.T) {
+	c := &Conn{brokerConn: newTestBrokerConn(t, 0), stationUpdatesSubs: make(map[string]*stationUpdateSub), schemaUpdateCbs: make(map[string][]SchemaUpdateHandler), events: make(chan ConnEvent, 10), opts: getDefaultOptions()}
+	if err := c.listenToSchemaUpdates("Station.A", nil); err != nil {
+		t.Fatal(err)
+	}
+	c.stationUpdatesSubs[getInternalName("Station.A")].notify(SchemaUpdate{})
+
+	event := <-c.Events()
+	if event.Type != ConnEventSchemaUpdated || event.StationName != "Station.A" {
+		t.Errorf("expected a schema update of Station.A, got %+v", event)
+	}
+}
+
+func TestOnSchemaUpdate(t *testing.T) {
+	c := &Conn{brokerConn: newTestBrokerConn(t, 0), stationUpdatesSubs: make(map[string]*stationUpdateSub), schemaUpdateCbs: make(map[string][]SchemaUpdateHandler), opts: getDefaultOptions()}
+	release := make(chan struct{})
+	defer close(release)
+	updates := make(chan SchemaUpdate, 2)
+	(&Station{Name: "Station.A", conn: c}).OnSchemaUpdate(func(update SchemaUpdate) {
+		updates <- update
+		<-release
+	})
+	(&Station{Name: "station_b", conn: c}).OnSchemaUpdate(func(update SchemaUpdate) {
+		t.Error("handlers of other stations shouldn't be called")
+	})
+	if err := c.listenToSchemaUpdates("station.a", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sus := c.stationUpdatesSubs[getInternalName("Station.A")]
+	sus.schemaUpdateCh <- SchemaUpdate{UpdateType: SchemaUpdateTypeInit, Init: SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    "json",
+		ActiveVersion: SchemaVersion{VersionNumber: 2, Content: `{"type": "object"}`},
+	}}
+	// the first handler call is still blocked, the drop has to be processed regardless
+	sus.schemaUpdateCh <- SchemaUpdate{UpdateType: SchemaUpdateTypeDrop}
+
+	got := map[SchemaUpdateType]SchemaUpdate{}
+	for i := 0; i < 2; i++ {
+		select {
+		case update := <-updates:
+			got[update.UpdateType] = update
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a handler call per update")
+		}
+	}
+	if got[SchemaUpdateTypeInit].Init.ActiveVersion.VersionNumber != 2 {
+		t.Errorf("expected the init update with the new active version, got %+v", got)
+	}
+	if _, ok := got[SchemaUpdateTypeDrop]; !ok {
+		t.Errorf("expected the drop update, got %+v", got)
+	}
+}
+
+func TestSchemaFetchRetry(t *testing.T) {
+	c := &Conn{brokerConn: newTestBrokerConn(t, 300*time.Millisecond), stationUpdatesSubs: make(map[string]*stationUpdateSub), opts: getDefaultOptions()}
+	c.opts.SchemaFetchTimeout = 600 * time.Millisecond
+	if err := WithSchemaFetchRetry(3, func(int) time.Duration { return 10 * time.Millisecond })(&c.opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.listenToConfirmedSchemaUpdates("station_name", nil); err != nil {
+		t.Fatalf("a delayed broker should be retried, got %v", err)
+	}
+	if sus := c.stationUpdatesSubs[getInternalName("station_name")]; sus == nil || sus.refCount != 1 {
+		t.Error("failed attempts shouldn't leave listener references behind")
+	}
+
+	c = &Conn{brokerConn: newTestBrokerConn(t, 300*time.Millisecond), stationUpdatesSubs: make(map[string]*stationUpdateSub), opts: getDefaultOptions()}
+	c.opts.SchemaFetchTimeout = 200 * time.Millisecond
+	c.opts.SchemaFetchAttempts = 1
+	if err := c.listenToConfirmedSchemaUpdates("station_name", nil); !errors.Is(err, ErrSchemaFetchTimeout) {
+		t.Errorf("expected ErrSchemaFetchTimeout without retries, got %v", err)
+	}
+	if len(c.stationUpdatesSubs) != 0 {
+		t.Error("an unconfirmed listener should be removed")
+	}
+}
+
+func TestProducerCreationSchemaFetchTimeout(t *testing.T) {
+	c := &Conn{brokerConn: newTestBrokerConn(t, 0), opts: getDefaultOptions()}
+	c.opts.SchemaFetchTimeout = 200 * time.Millisecond
+	p := &Producer{Name: "producer_name", stationName: "station_name", conn: c}
+
+	start := time.Now()
+	err := c.requestProducerCreation(p)
+	if !errors.Is(err, ErrSchemaFetchTimeout) || !errors.Is(err, nats.ErrTimeout) {
+		t.Errorf("expected ErrSchemaFetchTimeout wrapping nats.ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the creation request should be bounded by the schema fetch timeout, took %v", elapsed)
+	}
+
+	if err := schemaFetchErr(ErrStationNotFound, time.Second); err != ErrStationNotFound {
+		t.Errorf("errors other than timeouts should be returned as is, got %v", err)
+	}
+}
+
+func TestUnsupportedSchemaType(t *testing.T) {
+	c := &Conn{opts: getDefaultOptions()}
+	p := Producer{conn: c}
+	sd := schemaDetails{}
+	sd.setSchemaUpdateInit(SchemaUpdateInit{SchemaName: "schema_name", SchemaType: "avro"})
+
+	_, err := p.validateMsgWithSchema(sd, []byte("msg"), "", false)
+	var unsupported ErrUnsupportedSchemaType
+	if !errors.As(err, &unsupported) || unsupported.Type != "avro" {
+		t.Errorf("expected ErrUnsupportedSchemaType, got %v", err)
+	}
+
+	if err := WithUnknownSchemaPassthrough()(&c.opts); err != nil {
+		t.Fatal(err)
+	}
+	msgBytes, err := p.validateMsgWithSchema(sd, []byte("msg"), "", false)
+	if err != nil || string(msgBytes) != "msg" {
+		t.Errorf("expected the message to pass through, got %q %v", msgBytes, err)
+	}
+	if _, err := p.validateMsgWithSchema(sd, map[string]interface{}{"id": 1}, "", false); !errors.Is(err, ErrUnsupportedMsgType) {
+		t.Errorf("expected ErrUnsupportedMsgType for a non []byte message, got %v", err)
+	}
+}
+
+func TestSchemaTypeJSON(t *testing.T) {
+	var sui SchemaUpdateInit
+	if err := json.Unmarshal([]byte(`{"schema_name": "schema_name", "type": "graphql"}`), &sui); err != nil {
+		t.Fatal(err)
+	}
+	if sui.SchemaType != SchemaTypeGraphQL {
+		t.Errorf("unexpected schema type %q", sui.SchemaType)
+	}
+	data, err := json.Marshal(SchemaUpdateInit{SchemaType: SchemaTypeProtobuf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"type":"protobuf"`) {
+		t.Errorf("unexpected encoding %s", data)
+	}
+}
+
+func TestValidateGraphQlMsg(t *testing.T) {
+	sd := schemaDetails{}
+	sd.setSchemaUpdateInit(SchemaUpdateInit{
+		SchemaName:    "schema_name",
+		SchemaType:    SchemaTypeGraphQL,
+		ActiveVersion: SchemaVersion{VersionNumber: 1, Content: "type Query { order(id: ID!): Order } type Order { id: ID! total: Float }"},
+	})
+	if err := sd.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte(`query { order(id: "1") { id total } }`)
+	if msgBytes, err := sd.validateMsg(msg, ""); err != nil || !bytes.Equal(msgBytes, msg) {
+		t.Errorf("expected a valid document, got %q %v", msgBytes, err)
+	}
+	if _, err := sd.validateMsg([]byte(`query { order(id: "1") { missing } }`), ""); err == nil {
+		t.Error("expected error for a document not matching the schema")
+	}
+	if _, err := sd.validateMsg([]byte("not graphql"), ""); err == nil {
+		t.Error("expected error for an invalid document")
+	}
+	if _, err := sd.validateMsg(map[string]interface{}{"id": 1}, ""); !errors.Is(err, ErrUnsupportedMsgType) {
+		t.Errorf("expected ErrUnsupportedMsgType, got %v", err)
+	}
+}
+
+func TestCheckSchemaCompatibilityProto(t *testing.T) {
+	version := func(fields ...*descriptorpb.FieldDescriptorProto) SchemaVersion {
+		fileDesc := &descriptorpb.FileDescriptorProto{
+			Name:        proto.String("order.proto"),
+			Syntax:      proto.String("proto2"),
+			MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Order"), Field: fields}},
+		}
+		descriptor, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fileDesc}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return SchemaVersion{Descriptor: string(descriptor), MessageStructName: "Order"}
+	}
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{Name: proto.String(name), JsonName: proto.String(name), Number: proto.Int32(number), Type: typ.Enum(), Label: label.Enum()}
+	}
+	optional, required := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REQUIRED
+	str, i64 := descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_INT64
+
+	current := version(field("id", 1, str, required), field("note", 2, str, optional))
+	report, err := CheckSchemaCompatibility(current, version(field("id", 1, str, required), field("note", 2, str, optional), field("total", 3, i64, optional)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.BackwardCompatible || !report.ForwardCompatible || len(report.Changes) != 1 || report.Changes[0].Change != FieldAdded {
+		t.Errorf("adding an optional field: %+v", report)
+	}
+
+	report, err = CheckSchemaCompatibility(current, version(field("id", 1, i64, required)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.BackwardCompatible || report.ForwardCompatible {
+		t.Errorf("retyping a field must be incompatible: %+v", report)
+	}
+	incompatibilities := report.Incompatibilities()
+	if len(incompatibilities) != 1 || incompatibilities[0].Field != "id" || incompatibilities[0].Change != FieldRetyped {
+		t.Errorf("unexpected incompatibilities: %+v", incompatibilities)
+	}
+	if len(report.Changes) != 2 || report.Changes[1].Change != FieldRemoved {
+		t.Errorf("removing an optional field must be reported: %+v", report.Changes)
+	}
+
+	if _, err := CheckSchemaCompatibility(current, SchemaVersion{Content: "{}"}); err == nil {
+		t.Error("comparing protobuf with JSON schema must fail")
+	}
+}
+
+func TestCheckSchemaCompatibilityJSON(t *testing.T) {
+	current := SchemaVersion{Content: `{"type": "object", "required": ["id"], "properties": {
+		"id": {"type": "string"},
+		"customer": {"type": "object", "properties": {"name": {"type": "string"}}}}}`}
+	candidate := SchemaVersion{Content: `{"type": "object", "required": ["id", "total"], "properties": {
+		"id": {"type": "string"},
+		"total": {"type": "number"},
+		"customer": {"type": "object", "properties": {"name": {"type": "integer"}}}}}`}
+
+	report, err := CheckSchemaCompatibility(current, candidate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.BackwardCompatible || report.ForwardCompatible {
+		t.Errorf("expected an incompatible change: %+v", report)
+	}
+	want := []FieldChange{
+		{Field: "customer.name", Change: FieldRetyped, Detail: "type changed from string to integer"},
+		{Field: "total", Change: FieldAdded, Forward: true},
+	}
+	if len(report.Changes) != len(want) {
+		t.Fatalf("expected %d changes, got %+v", len(want), report.Changes)
+	}
+	for i := range want {
+		if report.Changes[i] != want[i] {
+			t.Errorf("change %d: expected %+v, got %+v", i, want[i], report.Changes[i])
+		}
+	}
+}
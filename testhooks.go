@@ -0,0 +1,73 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+//go:build memphis_testhooks
+
+package memphis
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// installTestDialer - dials the broker with a connTrackingDialer so ForceReconnect can close the connection.
+func installTestDialer(natsOpts *nats.Options) {
+	natsOpts.CustomDialer = &connTrackingDialer{dialer: net.Dialer{Timeout: natsOpts.Timeout}}
+}
+
+// Conn.ForceReconnect - closes the network connection to the broker so the client goes through a disconnect and
+// reconnect cycle, subscriptions (including schema updates listeners) are re-established on reconnect.
+// Only built with the memphis_testhooks build tag.
+func (c *Conn) ForceReconnect() error {
+	if !c.opts.Reconnect {
+		return memphisError(errors.New("reconnect is disabled for this connection"))
+	}
+	if c.brokerConn == nil {
+		return ErrDisconnected
+	}
+	d, ok := c.brokerConn.Opts.CustomDialer.(*connTrackingDialer)
+	if !ok {
+		return ErrDisconnected
+	}
+	return memphisError(d.closeConn())
+}
+
+// connTrackingDialer - dials connections to the broker and keeps the last one, so it can be closed to force a reconnect.
+type connTrackingDialer struct {
+	dialer net.Dialer
+	mu     sync.Mutex
+	conn   net.Conn
+}
+
+func (d *connTrackingDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.conn = conn
+	d.mu.Unlock()
+	return conn, nil
+}
+
+func (d *connTrackingDialer) closeConn() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		return ErrDisconnected
+	}
+	return d.conn.Close()
+}
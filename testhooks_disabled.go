@@ -0,0 +1,21 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+//go:build !memphis_testhooks
+
+package memphis
+
+import "github.com/nats-io/nats.go"
+
+// installTestDialer - the broker is dialed by nats unless built with the memphis_testhooks build tag.
+func installTestDialer(*nats.Options) {}
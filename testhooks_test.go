@@ -0,0 +1,92 @@
+// Credit for The NATS.IO Authors
+// Copyright 2021-2022 The Memphis Authors
+// Licensed under the Apache License, Version 2.0 (the “License”);
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an “AS IS” BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package server
+//go:build memphis_testhooks
+
+package memphis
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestConnTrackingDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d := &connTrackingDialer{}
+	if err := d.closeConn(); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("expected ErrDisconnected before dialing, got %v", err)
+	}
+
+	conn, err := d.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.closeConn(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("ping")); err == nil {
+		t.Error("expected the dialed connection to be closed")
+	}
+}
+
+func TestConsumerResumesAfterReconnect(t *testing.T) {
+	c, err := Connect("localhost", "root", "memphis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s, err := c.CreateStation("station_name_reconnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Destroy()
+
+	p, err := s.CreateProducer("producer_name_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := s.CreateConsumer("consumer_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ForceReconnect(); err != nil {
+		t.Fatal(err)
+	}
+	for event := range c.Events() {
+		if event.Type == ConnEventReconnected {
+			break
+		}
+	}
+
+	if err := p.Produce([]byte("after reconnect")); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := consumer.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || string(msgs[0].Data()) != "after reconnect" {
+		t.Errorf("expected the consumer to resume after the reconnect, got %v messages", len(msgs))
+	}
+	for _, msg := range msgs {
+		msg.Ack()
+	}
+}
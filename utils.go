@@ -1,14 +1,257 @@
 package memphis
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"strings"
+	"sync"
+	"time"
 )
 
+type memphisErr struct {
+	message string
+	err     error
+}
+
+func (e *memphisErr) Error() string {
+	return e.message
+}
+
+func (e *memphisErr) Unwrap() error {
+	return e.err
+}
+
 func memphisError(err error) error {
 	if err == nil {
 		return nil
 	}
 	message := strings.Replace(err.Error(), "nats", "memphis", -1)
-	return errors.New(message)
+	return &memphisErr{message: message, err: err}
+}
+
+// marshalJSON - marshals v with marshal, json.Marshal is used when marshal is nil.
+func marshalJSON(marshal JSONMarshalFunc, v any) ([]byte, error) {
+	if marshal == nil {
+		return json.Marshal(v)
+	}
+	return marshal(v)
+}
+
+// unmarshalJSON - unmarshals data into v with unmarshal, json.Unmarshal is used when unmarshal is nil.
+func unmarshalJSON(unmarshal JSONUnmarshalFunc, data []byte, v any) error {
+	if unmarshal == nil {
+		return json.Unmarshal(data, v)
+	}
+	return unmarshal(data, v)
+}
+
+// Conn.marshalJSON - marshals v with the connection's JSON marshaler.
+func (c *Conn) marshalJSON(v any) ([]byte, error) {
+	if c == nil {
+		return json.Marshal(v)
+	}
+	return marshalJSON(c.opts.JSONMarshaler, v)
+}
+
+// Conn.unmarshalJSON - unmarshals data into v with the connection's JSON unmarshaler.
+func (c *Conn) unmarshalJSON(data []byte, v any) error {
+	if c == nil {
+		return json.Unmarshal(data, v)
+	}
+	return unmarshalJSON(c.opts.JSONUnmarshaler, data, v)
+}
+
+// categorizedErr - an error matching both its category and its cause with errors.Is and errors.As.
+type categorizedErr struct {
+	category error
+	cause    error
+}
+
+func (e *categorizedErr) Error() string {
+	return e.category.Error() + ": " + e.cause.Error()
+}
+
+func (e *categorizedErr) Unwrap() error {
+	return e.cause
+}
+
+func (e *categorizedErr) Is(target error) bool {
+	return target == e.category
+}
+
+type multiError struct {
+	errs []error
+}
+
+func (e *multiError) Error() string {
+	msgs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *multiError) Unwrap() []error {
+	return e.errs
+}
+
+// joinErrors - returns an error wrapping all the non nil errors, nil if there are none.
+func joinErrors(errs ...error) error {
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	if len(nonNil) == 0 {
+		return nil
+	}
+	return &multiError{errs: nonNil}
+}
+
+// CircuitState - state of a producer's circuit breaker.
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	return [...]string{"closed", "open", "half-open"}[s]
+}
+
+// circuitBreaker - opens after threshold consecutive failures, after cooldown a single probe is allowed in half-open state.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     CircuitState
+	openedAt  time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow - whether a call may go through, moves the breaker to half-open once the cooldown elapsed.
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.cooldown {
+		cb.state = CircuitHalfOpen
+	}
+	switch cb.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+	}
+	return true
+}
+
+// done - records the result of an allowed call, calls that didn't reach the broker only release the half-open probe.
+func (cb *circuitBreaker) done(attempted bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitHalfOpen {
+		cb.probing = false
+	}
+	if !attempted || errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil {
+		cb.state = CircuitClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = CircuitOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+func (cb *circuitBreaker) currentState() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.cooldown {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// rateLimiter - a token bucket allowing rate events per second with bursts of up to burst events.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(perSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (rl *rateLimiter) refill(now time.Time) {
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+}
+
+// rateLimiter.allow - takes a token if one is available.
+func (rl *rateLimiter) allow() bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill(time.Now())
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
+
+// rateLimiter.wait - takes a token, waiting until one is available or the context is done.
+func (rl *rateLimiter) wait(ctx context.Context) error {
+	rl.mu.Lock()
+	rl.refill(time.Now())
+	rl.tokens--
+	tokens := rl.tokens
+	rl.mu.Unlock()
+
+	if tokens >= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(-tokens / rl.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		rl.mu.Lock()
+		rl.tokens++
+		rl.mu.Unlock()
+		return ctx.Err()
+	}
 }